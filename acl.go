@@ -0,0 +1,264 @@
+// acl.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Actions of a proxy ACL entry
+var proxy_acl_actions = []string{"allow", "deny"}
+
+// Number of denied requests kept for the dashboard
+const max_acl_denials = 100
+
+// Time allowed to resolve a destination name for IP and CIDR ACL entries
+const acl_resolve_timeout = 5 * time.Second
+
+/*
+A proxy request refused by an ACL entry
+*/
+type acl_denial struct {
+	Time        time.Time `json:"time"`
+	SourceIP    string    `json:"source_ip"`
+	Destination string    `json:"destination"`
+	Protocol    string    `json:"protocol"` // "SOCKS5", "SOCKS4", "HTTP" or "UDP"
+	EntryID     int       `json:"entry_id"`
+	Entry       string    `json:"entry"`
+}
+
+// ACL entries in priority order and their hit counters by entry ID, protected by proxy_acls_mutex
+var proxy_acls []DBProxyACL
+var proxy_acl_hits = make(map[int]*int64)
+var proxy_acls_mutex sync.RWMutex
+
+// Recent denials, protected by acl_denials_mutex
+var acl_denials []acl_denial
+var acl_denials_mutex sync.Mutex
+
+/*
+Check whether a proxy ACL action is supported
+*/
+func valid_proxy_acl_action(action string) bool {
+	for _, a := range proxy_acl_actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Load ACL entries from the database into memory. Hit counters of entries that
+still exist are kept.
+*/
+func load_proxy_acls() {
+	entries, err := loadProxyACLs()
+	if err != nil {
+		log.Printf("[WARN] Failed to load ACL entries from database: %v", err)
+		return
+	}
+
+	valid := entries[:0]
+	for _, entry := range entries {
+		if entry.Source != "*" && !valid_source_pattern(entry.Source) {
+			log.Printf("[WARN] Skipping ACL entry %d with invalid source %q", entry.ID, entry.Source)
+			continue
+		}
+		valid = append(valid, entry)
+	}
+
+	proxy_acls_mutex.Lock()
+	defer proxy_acls_mutex.Unlock()
+
+	hits := make(map[int]*int64, len(valid))
+	for _, entry := range valid {
+		if counter, exists := proxy_acl_hits[entry.ID]; exists {
+			hits[entry.ID] = counter
+		} else {
+			hits[entry.ID] = new(int64)
+		}
+	}
+	proxy_acls, proxy_acl_hits = valid, hits
+}
+
+/*
+ACL entries in priority order with their hit counters
+*/
+func get_proxy_acls() []DBProxyACL {
+	proxy_acls_mutex.RLock()
+	defer proxy_acls_mutex.RUnlock()
+
+	entries := make([]DBProxyACL, len(proxy_acls))
+	for i, entry := range proxy_acls {
+		entry.Hits = atomic.LoadInt64(proxy_acl_hits[entry.ID])
+		entries[i] = entry
+	}
+	return entries
+}
+
+/*
+Recent denials, newest first
+*/
+func get_acl_denials() []acl_denial {
+	acl_denials_mutex.Lock()
+	defer acl_denials_mutex.Unlock()
+
+	denials := make([]acl_denial, len(acl_denials))
+	for i, denial := range acl_denials {
+		denials[len(acl_denials)-1-i] = denial
+	}
+	return denials
+}
+
+/*
+Describe an ACL entry for logs and the deny log
+*/
+func describe_proxy_acl(entry DBProxyACL) string {
+	destination := entry.Destination
+	if entry.Port != 0 {
+		destination = fmt.Sprintf("%s port %d", destination, entry.Port)
+	}
+	return fmt.Sprintf("ACL entry %d (%s %s -> %s)", entry.ID, entry.Action, entry.Source, destination)
+}
+
+/*
+Check whether an ACL entry matches a source IP and a destination (host:port).
+IP and CIDR entries also match on the addresses a destination name resolved to:
+a deny entry when any of them is covered, an allow entry only when all are, so
+a name cannot carry a denied address past an allow entry.
+*/
+func match_proxy_acl(entry DBProxyACL, source_ip string, destination string, resolved []net.IP) bool {
+	if entry.Source != "*" {
+		ip := net.ParseIP(source_ip)
+		if ip == nil {
+			return false
+		}
+		if _, ok := source_pattern_covers(entry.Source, ip.To16()); !ok {
+			return false
+		}
+	}
+	if match_destination(entry.Destination, entry.Port, destination) {
+		return true
+	}
+	if len(resolved) == 0 || !ip_destination_pattern(entry.Destination) {
+		return false
+	}
+
+	_, port, _ := net.SplitHostPort(destination)
+	deny := entry.Action == "deny"
+	for _, ip := range resolved {
+		if covered := match_destination(entry.Destination, entry.Port, net.JoinHostPort(ip.String(), port)); covered == deny {
+			return covered
+		}
+	}
+	return !deny
+}
+
+/*
+Resolve the host of a destination (host:port) when it is a name and an enabled
+ACL entry matches on IP addresses. Returns no addresses otherwise.
+*/
+func resolve_acl_destination(destination string) ([]net.IP, error) {
+	host, _, err := net.SplitHostPort(destination)
+	if err != nil {
+		host = destination
+	}
+	if net.ParseIP(host) != nil {
+		return nil, nil
+	}
+
+	needed := false
+	proxy_acls_mutex.RLock()
+	for _, e := range proxy_acls {
+		if e.Enabled && ip_destination_pattern(e.Destination) {
+			needed = true
+			break
+		}
+	}
+	proxy_acls_mutex.RUnlock()
+	if !needed {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), acl_resolve_timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		resolved[i] = addr.IP
+	}
+	return resolved, nil
+}
+
+/*
+Check a proxy request of a source IP to a destination (host:port) against the
+ACL. A destination name is resolved first when IP or CIDR entries have to be
+checked; a name that cannot be resolved then is denied, since the dial could
+resolve it to a denied address.
+*/
+func check_proxy_acl(source_ip string, destination string, protocol string) bool {
+	resolved, err := resolve_acl_destination(destination)
+	if err != nil {
+		log.Printf("[WARN] %s request from %s to %s denied: could not resolve for the ACL: %v", protocol, source_ip, destination, err)
+		record_acl_denial(source_ip, destination, protocol, 0, "unresolvable destination")
+		return false
+	}
+	return check_proxy_acl_addresses(source_ip, destination, protocol, resolved)
+}
+
+/*
+Check a proxy request of a source IP to a destination (host:port) that resolved
+to the given addresses against the ACL. The first enabled matching entry decides
+and counts a hit; without a matching entry the request is allowed. Denials are
+logged and kept for the dashboard.
+*/
+func check_proxy_acl_addresses(source_ip string, destination string, protocol string, resolved []net.IP) bool {
+	var entry DBProxyACL
+	matched := false
+	proxy_acls_mutex.RLock()
+	for _, e := range proxy_acls {
+		if e.Enabled && match_proxy_acl(e, source_ip, destination, resolved) {
+			atomic.AddInt64(proxy_acl_hits[e.ID], 1)
+			entry, matched = e, true
+			break
+		}
+	}
+	proxy_acls_mutex.RUnlock()
+
+	if !matched || entry.Action == "allow" {
+		return true
+	}
+
+	log.Printf("[WARN] %s request from %s to %s denied by %s", protocol, source_ip, destination, describe_proxy_acl(entry))
+	record_acl_denial(source_ip, destination, protocol, entry.ID, describe_proxy_acl(entry))
+	return false
+}
+
+/*
+Keep a denied request for the dashboard
+*/
+func record_acl_denial(source_ip string, destination string, protocol string, entry_id int, entry string) {
+	acl_denials_mutex.Lock()
+	defer acl_denials_mutex.Unlock()
+
+	acl_denials = append(acl_denials, acl_denial{
+		Time:        time.Now(),
+		SourceIP:    source_ip,
+		Destination: destination,
+		Protocol:    protocol,
+		EntryID:     entry_id,
+		Entry:       entry,
+	})
+	if len(acl_denials) > max_acl_denials {
+		acl_denials = acl_denials[len(acl_denials)-max_acl_denials:]
+	}
+}
@@ -0,0 +1,214 @@
+// affinity.go
+package main
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scopes a sticky session can be keyed on besides the source IP
+var affinity_scopes = []string{"host", "domain"}
+
+/*
+A sticky session: follow-up connections from a source IP to the same
+destination use the same load balancer until the entry expires.
+*/
+type affinity_entry struct {
+	SourceIP    string    `json:"source_ip"`
+	Destination string    `json:"destination"` // host or registrable domain, by scope
+	LBAddress   string    `json:"lb_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Hits        int       `json:"hits"`
+}
+
+// Sticky sessions by source IP and destination, protected by mutex
+var affinity_table = make(map[string]*affinity_entry)
+
+// Second-level labels under which country domains are registered (example.co.uk)
+var affinity_second_level_labels = map[string]bool{
+	"co": true, "com": true, "net": true, "org": true, "gov": true,
+	"edu": true, "ac": true, "or": true, "ne": true, "go": true,
+}
+
+/*
+Check whether an affinity scope is supported
+*/
+func valid_affinity_scope(scope string) bool {
+	for _, s := range affinity_scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Registrable domain of a host name: the last two labels, or three when the
+second-to-last one is a common second-level label of a country domain.
+This is an approximation of the public suffix list that covers the usual cases.
+*/
+func get_registrable_domain(host string) string {
+	labels := strings.Split(host, ".")
+	n := 2
+	if len(labels) > 2 && len(labels[len(labels)-1]) == 2 && affinity_second_level_labels[labels[len(labels)-2]] {
+		n = 3
+	}
+	if len(labels) <= n {
+		return host
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+/*
+Destination part of the affinity key for a host:port or host, by the configured
+scope. IP addresses are always used as they are.
+*/
+func get_affinity_destination(destination string) string {
+	host := destination
+	if h, _, err := net.SplitHostPort(destination); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if host == "" || net.ParseIP(host) != nil || currentSettings.AffinityScope == "host" {
+		return host
+	}
+	return get_registrable_domain(host)
+}
+
+func affinity_key(source_ip string, destination string) string {
+	return source_ip + "|" + destination
+}
+
+/*
+Load balancer pinned for a source IP and destination, or -1. Pins that expired,
+point to a removed load balancer or to one that is not a candidate (disabled or
+failed for this connection), or whose last dial hit a link failure, are dropped
+so normal selection takes over. Called with mutex held.
+*/
+func lookup_affinity(source_ip string, destination string, candidates []int) int {
+	key := affinity_key(source_ip, destination)
+	entry, exists := affinity_table[key]
+	if !exists {
+		return -1
+	}
+
+	if i := peek_affinity(source_ip, destination, candidates); i >= 0 {
+		entry.Hits++
+		entry.ExpiresAt = time.Now().Add(time.Duration(currentSettings.AffinityTTL) * time.Second)
+		return i
+	}
+	if time.Now().Before(entry.ExpiresAt) {
+		log.Printf("[DEBUG] Sticky session %s -> %s released, %s is unavailable", source_ip, destination, entry.LBAddress)
+	}
+
+	delete(affinity_table, key)
+	return -1
+}
+
+/*
+Load balancer of a valid pin for a source IP and destination, or -1, without
+touching the pin. Called with mutex held.
+*/
+func peek_affinity(source_ip string, destination string, candidates []int) int {
+	entry, exists := affinity_table[affinity_key(source_ip, destination)]
+	if !exists || !time.Now().Before(entry.ExpiresAt) {
+		return -1
+	}
+
+	for _, i := range candidates {
+		if lb_list[i].address == entry.LBAddress && lb_list[i].consecutive_failures == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Pin a source IP and destination to a load balancer. Called with mutex held.
+*/
+func store_affinity(source_ip string, destination string, lb *enhanced_load_balancer) {
+	now := time.Now()
+	affinity_table[affinity_key(source_ip, destination)] = &affinity_entry{
+		SourceIP:    source_ip,
+		Destination: destination,
+		LBAddress:   lb.address,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Duration(currentSettings.AffinityTTL) * time.Second),
+	}
+}
+
+/*
+Pin a source IP and destination (host:port) to a load balancer if sticky
+sessions are on
+*/
+func pin_affinity(source_ip string, destination string, lb *enhanced_load_balancer) {
+	if currentSettings.AffinityTTL <= 0 {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	store_affinity(source_ip, get_affinity_destination(destination), lb)
+}
+
+/*
+Remove expired sticky sessions
+*/
+func purge_expired_affinities() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	for key, entry := range affinity_table {
+		if !now.Before(entry.ExpiresAt) {
+			delete(affinity_table, key)
+		}
+	}
+}
+
+/*
+Current sticky sessions, sorted by source IP and destination
+*/
+func get_affinities() []affinity_entry {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	entries := make([]affinity_entry, 0, len(affinity_table))
+	for _, entry := range affinity_table {
+		if now.Before(entry.ExpiresAt) {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].SourceIP != entries[b].SourceIP {
+			return entries[a].SourceIP < entries[b].SourceIP
+		}
+		return entries[a].Destination < entries[b].Destination
+	})
+	return entries
+}
+
+/*
+Remove sticky sessions matching a source IP and destination; empty values
+match everything. Returns the number of removed entries.
+*/
+func clear_affinities(source_ip string, destination string) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	removed := 0
+	for key, entry := range affinity_table {
+		if (source_ip == "" || entry.SourceIP == source_ip) && (destination == "" || entry.Destination == destination) {
+			delete(affinity_table, key)
+			removed++
+		}
+	}
+	return removed
+}
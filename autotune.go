@@ -0,0 +1,188 @@
+// autotune.go
+package main
+
+import (
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Shortest interval between two auto-tune rounds
+const min_auto_tune_interval = 10 * time.Second
+
+// Number of ratio adjustments returned by the auto-tune API
+const max_ratio_adjustments = 100
+
+/*
+Contention ratio of a load balancer without source IP rules: the auto-tuned
+ratio while auto-tune is on and the load balancer has been tuned, otherwise the
+configured one
+*/
+func get_base_contention_ratio(lb *enhanced_load_balancer) int {
+	if currentSettings.AutoTune && lb.tuned_ratio > 0 {
+		return lb.tuned_ratio
+	}
+	return lb.contention_ratio
+}
+
+/*
+Run auto-tune rounds while the proxy is running. When auto-tune is switched off
+the tuned ratios are dropped so the configured ratios apply again.
+*/
+func auto_tune_loop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last_round := time.Now()
+	for range ticker.C {
+		// Read the interval every second so a changed setting applies right away
+		interval := time.Duration(currentSettings.AutoTuneInterval) * time.Second
+		if interval < min_auto_tune_interval {
+			interval = min_auto_tune_interval
+		}
+		if time.Since(last_round) < interval {
+			continue
+		}
+		last_round = time.Now()
+
+		if currentSettings.AutoTune {
+			run_auto_tune()
+		} else {
+			clear_tuned_ratios()
+		}
+	}
+}
+
+/*
+Recompute the contention ratio of every enabled load balancer from what it did
+since the last round. Its score is the throughput per active connection, scaled
+by the share of successful dials and by how close its connect latency is to the
+fastest link (latency_tolerance_ms is added to both so small differences hardly
+count). The best link gets the maximum ratio, the others a proportional
+one within the bounds; the result is smoothed against the previous ratio.
+Links that carried no traffic keep their ratio. Every change is logged and
+written to the audit log.
+*/
+func run_auto_tune() {
+	type measurement struct {
+		index        int
+		throughput   float64
+		success_rate float64
+		latency      float64
+		score        float64
+	}
+
+	min_ratio := currentSettings.AutoTuneMinRatio
+	if min_ratio < 1 {
+		min_ratio = 1
+	}
+	max_ratio := currentSettings.AutoTuneMaxRatio
+	if max_ratio < min_ratio {
+		max_ratio = min_ratio
+	}
+	smoothing := currentSettings.AutoTuneSmoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 1
+	}
+
+	mutex.Lock()
+
+	var measurements []measurement
+	fastest := 0.0
+	for i := range lb_list {
+		lb := &lb_list[i]
+		successes := lb.success_count - lb.tune_success_count
+		attempts := successes + lb.failure_count - lb.tune_failure_count
+		lb.tune_success_count, lb.tune_failure_count = lb.success_count, lb.failure_count
+
+		throughput := float64(lb.bytes_in_per_second + lb.bytes_out_per_second)
+		if !lb.enabled || throughput <= 0 {
+			continue
+		}
+
+		m := measurement{index: i, success_rate: 1, latency: lb.connect_latency}
+		if active := atomic.LoadInt64(&lb.active_count); active > 1 {
+			m.throughput = throughput / float64(active)
+		} else {
+			m.throughput = throughput
+		}
+		if attempts > 0 {
+			m.success_rate = float64(successes) / float64(attempts)
+		}
+		if lb.latency_samples > 0 && lb.connect_latency > 0 && (fastest == 0 || lb.connect_latency < fastest) {
+			fastest = lb.connect_latency
+		}
+		measurements = append(measurements, m)
+	}
+
+	best := 0.0
+	for k := range measurements {
+		m := &measurements[k]
+		m.score = m.throughput * m.success_rate
+		if fastest > 0 && m.latency > 0 {
+			tolerance := float64(currentSettings.LatencyToleranceMs)
+			m.score *= (fastest + tolerance) / (m.latency + tolerance)
+		}
+		best = math.Max(best, m.score)
+	}
+
+	var adjustments []DBRatioAdjustment
+	for _, m := range measurements {
+		if best <= 0 {
+			break
+		}
+		lb := &lb_list[m.index]
+
+		target := math.Max(float64(min_ratio), float64(max_ratio)*m.score/best)
+		if lb.tuned_ratio == 0 {
+			lb.tuned_weight = float64(lb.contention_ratio)
+		}
+		lb.tuned_weight += smoothing * (target - lb.tuned_weight)
+
+		old_ratio := get_base_contention_ratio(lb)
+		new_ratio := int(math.Round(lb.tuned_weight))
+		if new_ratio < min_ratio {
+			new_ratio = min_ratio
+		}
+		if new_ratio > max_ratio {
+			new_ratio = max_ratio
+		}
+		lb.tuned_ratio = new_ratio
+
+		if new_ratio != old_ratio {
+			adjustments = append(adjustments, DBRatioAdjustment{
+				LBAddress:   lb.address,
+				OldRatio:    old_ratio,
+				NewRatio:    new_ratio,
+				TargetRatio: target,
+				Throughput:  m.throughput,
+				SuccessRate: m.success_rate * 100,
+				LatencyMs:   m.latency,
+			})
+		}
+	}
+
+	mutex.Unlock()
+
+	for _, a := range adjustments {
+		log.Printf("[INFO] Auto-tune: ratio of %s %d -> %d (target %.1f, %.0f B/s per connection, %.0f%% success, %.1f ms)",
+			a.LBAddress, a.OldRatio, a.NewRatio, a.TargetRatio, a.Throughput, a.SuccessRate, a.LatencyMs)
+		if err := saveRatioAdjustment(a); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}
+}
+
+/*
+Drop all auto-tuned ratios
+*/
+func clear_tuned_ratios() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range lb_list {
+		lb_list[i].tuned_ratio = 0
+		lb_list[i].tuned_weight = 0
+	}
+}
@@ -0,0 +1,144 @@
+// client_policies.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Modes of a client policy
+var client_policy_modes = []string{"allow", "deny"}
+
+// Client policies by ID, protected by client_policies_mutex
+var client_policies []DBClientPolicy
+var client_policies_mutex sync.RWMutex
+
+/*
+Check whether a client policy mode is supported
+*/
+func valid_client_policy_mode(mode string) bool {
+	for _, m := range client_policy_modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Load client policies from the database into memory, skipping policies whose
+source pattern is not valid
+*/
+func load_client_policies() {
+	policies, err := loadClientPolicies()
+	if err != nil {
+		log.Printf("[WARN] Failed to load client policies from database: %v", err)
+		return
+	}
+
+	valid := policies[:0]
+	for _, policy := range policies {
+		if !valid_source_pattern(policy.Source) {
+			log.Printf("[WARN] Skipping client policy %d with invalid source %q", policy.ID, policy.Source)
+			continue
+		}
+		valid = append(valid, policy)
+	}
+
+	client_policies_mutex.Lock()
+	client_policies = valid
+	client_policies_mutex.Unlock()
+}
+
+/*
+Client policies for the web API
+*/
+func get_client_policies() []DBClientPolicy {
+	client_policies_mutex.RLock()
+	defer client_policies_mutex.RUnlock()
+
+	policies := make([]DBClientPolicy, len(client_policies))
+	copy(policies, client_policies)
+	return policies
+}
+
+/*
+Find the enabled client policy for a source IP: the policy of its exact IP,
+otherwise the most specific CIDR block or range containing it, like source IP
+rules
+*/
+func match_client_policy(source_ip string) (DBClientPolicy, bool) {
+	client_policies_mutex.RLock()
+	defer client_policies_mutex.RUnlock()
+
+	ip := net.ParseIP(source_ip)
+	if ip == nil {
+		return DBClientPolicy{}, false
+	}
+	ip = ip.To16()
+
+	var best_policy DBClientPolicy
+	var best_size *big.Int
+	for _, policy := range client_policies {
+		if !policy.Enabled {
+			continue
+		}
+		if policy.Source == source_ip {
+			return policy, true
+		}
+		if size, ok := source_pattern_covers(policy.Source, ip); ok && more_specific_source_pattern(size, policy.Source, best_size, best_policy.Source) {
+			best_policy, best_size = policy, size
+		}
+	}
+	return best_policy, best_size != nil
+}
+
+/*
+Check whether a client policy lets its clients use a load balancer
+*/
+func client_policy_allows(policy DBClientPolicy, address string) bool {
+	listed := false
+	for _, a := range policy.LBAddresses {
+		if a == address {
+			listed = true
+		}
+	}
+	return listed == (policy.Mode == "allow")
+}
+
+/*
+Describe a client policy for logs and explanations
+*/
+func describe_client_policy(policy DBClientPolicy) string {
+	return fmt.Sprintf("client policy %d (%s %s %s)", policy.ID, policy.Source, policy.Mode, strings.Join(policy.LBAddresses, ", "))
+}
+
+/*
+Drop the load balancers the client policy of a source IP excludes from the
+candidates. Returns the remaining candidates and why the others were dropped.
+Called with mutex held.
+*/
+func select_client_candidates(source_ip string, candidates []int) ([]int, map[int]string) {
+	policy, exists := match_client_policy(source_ip)
+	if !exists {
+		return candidates, nil
+	}
+
+	var dropped map[int]string
+	kept := make([]int, 0, len(candidates))
+	for _, i := range candidates {
+		if client_policy_allows(policy, lb_list[i].address) {
+			kept = append(kept, i)
+			continue
+		}
+		if dropped == nil {
+			dropped = make(map[int]string)
+		}
+		dropped[i] = "excluded by " + describe_client_policy(policy)
+	}
+	return kept, dropped
+}
@@ -35,3 +35,9 @@ const (
 	COMMAND_NOT_SUPPORTED  = iota
 	ADDRTYPE_NOT_SUPPORTED = iota
 )
+
+// SOCKS4 REPLY
+const (
+	SOCKS4_GRANTED  = 0x5A
+	SOCKS4_REJECTED = 0x5B
+)
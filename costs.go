@@ -0,0 +1,226 @@
+// costs.go
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Bytes per GB for cost accounting, metered links bill decimal gigabytes
+const cost_bytes_per_gb = 1e9
+
+// How often accumulated costs are written to the database
+const cost_save_interval = time.Minute
+
+/*
+Accumulated cost of the traffic of a client over metered load balancers
+*/
+type client_cost struct {
+	SourceIP     string  `json:"source_ip"`
+	Cost         float64 `json:"cost"`
+	MeteredBytes int64   `json:"metered_bytes"` // bytes through load balancers with a cost per GB
+}
+
+/*
+Cost configuration and accumulated cost of a load balancer
+*/
+type load_balancer_cost struct {
+	Address         string  `json:"address"`
+	CostPerGB       float64 `json:"cost_per_gb"`
+	CostTier        int     `json:"cost_tier"`
+	AccumulatedCost float64 `json:"accumulated_cost"`
+}
+
+// Accumulated cost per client and whether any cost changed since the last save, protected by mutex
+var client_costs = make(map[string]*client_cost)
+var costs_dirty bool
+
+/*
+Add the cost of traffic through a load balancer to its total and to the
+client's total. Called with mutex held.
+*/
+func account_traffic_cost(lb *enhanced_load_balancer, source_ip string, bytes int64) {
+	if lb.cost_per_gb <= 0 || bytes <= 0 {
+		return
+	}
+
+	cost := float64(bytes) / cost_bytes_per_gb * lb.cost_per_gb
+	lb.accumulated_cost += cost
+
+	client, exists := client_costs[source_ip]
+	if !exists {
+		client = &client_cost{SourceIP: source_ip}
+		client_costs[source_ip] = client
+	}
+	client.Cost += cost
+	client.MeteredBytes += bytes
+	costs_dirty = true
+}
+
+/*
+Restrict candidate load balancers to the cheapest cost tier that can take new
+connections. A cost tier is passed over while all of its load balancers spill
+over, so expensive links are only used under load or when the cheaper ones
+failed (unhealthy links are no candidates). When every candidate spills all of
+them are returned. Called with mutex held.
+*/
+func select_cost_candidates(candidates []int) []int {
+	return cost_candidates(candidates, current_spillover_state)
+}
+
+/*
+Like select_cost_candidates, without updating the spillover state.
+Called with mutex held.
+*/
+func peek_cost_candidates(candidates []int) []int {
+	return cost_candidates(candidates, peek_spillover_state)
+}
+
+func cost_candidates(candidates []int, spilling func(lb *enhanced_load_balancer) bool) []int {
+	best := 0
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		if !spilling(lb) && (best == 0 || lb.cost_tier < best) {
+			best = lb.cost_tier
+		}
+	}
+	if best == 0 {
+		return candidates
+	}
+
+	cheapest := make([]int, 0, len(candidates))
+	for _, i := range candidates {
+		if lb_list[i].cost_tier == best {
+			cheapest = append(cheapest, i)
+		}
+	}
+	return cheapest
+}
+
+/*
+Set the cost per GB and cost tier of a load balancer and persist them
+*/
+func set_load_balancer_cost(lb_address string, cost_per_gb float64, cost_tier int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range lb_list {
+		if lb_list[i].address == lb_address {
+			lb_list[i].cost_per_gb = cost_per_gb
+			lb_list[i].cost_tier = cost_tier
+
+			if err := saveLoadBalancerCost(lb_address, cost_per_gb, cost_tier); err != nil {
+				log.Printf("[WARN] Failed to save cost of load balancer %s: %v", lb_address, err)
+			}
+			log.Printf("[INFO] Cost of load balancer %s set to %.4f per GB, cost tier %d", lb_address, cost_per_gb, cost_tier)
+			return true
+		}
+	}
+
+	log.Printf("[WARN] Load balancer %s not found", lb_address)
+	return false
+}
+
+/*
+Accumulated costs per load balancer and per client (highest cost first) and their total
+*/
+func get_cost_report() ([]load_balancer_cost, []client_cost, float64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	total := 0.0
+	lbs := make([]load_balancer_cost, len(lb_list))
+	for i := range lb_list {
+		lb := &lb_list[i]
+		lbs[i] = load_balancer_cost{
+			Address:         lb.address,
+			CostPerGB:       lb.cost_per_gb,
+			CostTier:        lb.cost_tier,
+			AccumulatedCost: lb.accumulated_cost,
+		}
+		total += lb.accumulated_cost
+	}
+
+	clients := make([]client_cost, 0, len(client_costs))
+	for _, client := range client_costs {
+		clients = append(clients, *client)
+	}
+	sort.Slice(clients, func(a, b int) bool {
+		return clients[a].Cost > clients[b].Cost
+	})
+	return lbs, clients, total
+}
+
+/*
+Start a new billing period: reset all accumulated costs and persist that
+*/
+func reset_costs() {
+	mutex.Lock()
+	for i := range lb_list {
+		lb_list[i].accumulated_cost = 0
+	}
+	client_costs = make(map[string]*client_cost)
+	costs_dirty = false
+	mutex.Unlock()
+
+	if err := clearCostTotals(); err != nil {
+		log.Printf("[WARN] Failed to reset costs in database: %v", err)
+	}
+	log.Printf("[INFO] Accumulated costs reset")
+}
+
+/*
+Load accumulated client costs saved before the last restart; load balancer
+costs are loaded with the load balancers
+*/
+func load_cost_totals() {
+	dbClients, err := loadClientCosts()
+	if err != nil {
+		log.Printf("[WARN] Failed to load client costs from database: %v", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, dbClient := range dbClients {
+		client_costs[dbClient.SourceIP] = &client_cost{
+			SourceIP:     dbClient.SourceIP,
+			Cost:         dbClient.Cost,
+			MeteredBytes: dbClient.MeteredBytes,
+		}
+	}
+}
+
+/*
+Write accumulated costs to the database if they changed since the last save
+*/
+func save_cost_totals() {
+	mutex.Lock()
+	if !costs_dirty {
+		mutex.Unlock()
+		return
+	}
+	lb_costs := make(map[string]float64, len(lb_list))
+	for i := range lb_list {
+		lb_costs[lb_list[i].address] = lb_list[i].accumulated_cost
+	}
+	clients := make([]DBClientCost, 0, len(client_costs))
+	for _, client := range client_costs {
+		clients = append(clients, DBClientCost{
+			SourceIP:     client.SourceIP,
+			Cost:         client.Cost,
+			MeteredBytes: client.MeteredBytes,
+		})
+	}
+	costs_dirty = false
+	mutex.Unlock()
+
+	if err := saveCostTotals(lb_costs, clients); err != nil {
+		log.Printf("[WARN] Failed to save costs: %v", err)
+		mutex.Lock()
+		costs_dirty = true
+		mutex.Unlock()
+	}
+}
@@ -2,14 +2,21 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
 	_ "modernc.org/sqlite"
 )
 
@@ -25,6 +32,23 @@ type DBSettings struct {
 	TunnelMode      bool   `json:"tunnel_mode"`
 	DebugMode       bool   `json:"debug_mode"`
 	QuietMode       bool   `json:"quiet_mode"`
+	SocksAuth       bool   `json:"socks_auth"`
+	HTTPProxyPort   int    `json:"http_proxy_port"`
+	HTTPProxyAuth   bool   `json:"http_proxy_auth"`
+	LBStrategy      string `json:"lb_strategy"`
+	LatencyToleranceMs int `json:"latency_tolerance_ms"`
+	AffinityTTL     int    `json:"affinity_ttl"`
+	AffinityScope   string `json:"affinity_scope"`
+	SpilloverHysteresisPct int `json:"spillover_hysteresis_pct"`
+	AutoTune        bool    `json:"auto_tune"`
+	AutoTuneInterval int    `json:"auto_tune_interval"`
+	AutoTuneMinRatio int    `json:"auto_tune_min_ratio"`
+	AutoTuneMaxRatio int    `json:"auto_tune_max_ratio"`
+	AutoTuneSmoothing float64 `json:"auto_tune_smoothing"`
+	DialMaxAttempts int    `json:"dial_max_attempts"`
+	DialTimeBudget  int    `json:"dial_time_budget"`
+	HappyEyeballs   bool   `json:"happy_eyeballs"`
+	HappyEyeballsDelayMs int `json:"happy_eyeballs_delay_ms"`
 	CreatedAt       string `json:"created_at"`
 	UpdatedAt       string `json:"updated_at"`
 }
@@ -39,6 +63,14 @@ type DBLoadBalancer struct {
 	SuccessCount      int    `json:"success_count"`
 	FailureCount      int    `json:"failure_count"`
 	BytesTransferred  int64  `json:"bytes_transferred"`
+	CapacityDownMbps  int    `json:"capacity_down_mbps"`
+	CapacityUpMbps    int    `json:"capacity_up_mbps"`
+	Tier              int    `json:"tier"`
+	SpillUtilizationPct int  `json:"spill_utilization_pct"`
+	SpillMaxConnections int  `json:"spill_max_connections"`
+	CostPerGB         float64 `json:"cost_per_gb"`
+	CostTier          int    `json:"cost_tier"`
+	AccumulatedCost   float64 `json:"accumulated_cost"`
 	CreatedAt         string `json:"created_at"`
 	UpdatedAt         string `json:"updated_at"`
 }
@@ -66,6 +98,96 @@ type DBSourceIPRule struct {
 	UpdatedAt       string `json:"updated_at"`
 }
 
+type DBProxyUser struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	Enabled      bool   `json:"enabled"`
+	Description  string `json:"description"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+type DBListener struct {
+	ID          int    `json:"id"`
+	BindHost    string `json:"bind_host"`
+	BindPort    int    `json:"bind_port"`
+	Mode        string `json:"mode"` // "socks", "tunnel" or "http"
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"` // seeded from the listen settings, cannot be deleted
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type DBClientCost struct {
+	SourceIP     string  `json:"source_ip"`
+	Cost         float64 `json:"cost"`
+	MeteredBytes int64   `json:"metered_bytes"`
+	UpdatedAt    string  `json:"updated_at"`
+}
+
+type DBRaceRule struct {
+	ID          int    `json:"id"`
+	Destination string `json:"destination"` // "*", domain suffix "*.example.com", CIDR, IP or host
+	Port        int    `json:"port"`        // 0 = any port
+	Race        bool   `json:"race"`
+	DelayMs     int    `json:"delay_ms"`    // 0 = happy_eyeballs_delay_ms
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type DBDestinationRule struct {
+	ID          int    `json:"id"`
+	Priority    int    `json:"priority"`    // lower numbers are applied first
+	Destination string `json:"destination"` // "*", domain suffix "*.example.com", CIDR, IP or host
+	Port        int    `json:"port"`        // 0 = any port
+	Action      string `json:"action"`      // "pin", "prefer" or "avoid"
+	LBAddress   string `json:"lb_address"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type DBClientPolicy struct {
+	ID          int      `json:"id"`
+	Source      string   `json:"source"`       // IP, CIDR block or IP range of the clients
+	Mode        string   `json:"mode"`         // "allow": only these load balancers, "deny": all but these
+	LBAddresses []string `json:"lb_addresses"`
+	Enabled     bool     `json:"enabled"`
+	Description string   `json:"description"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+type DBProxyACL struct {
+	ID          int    `json:"id"`
+	Priority    int    `json:"priority"`    // lower numbers are evaluated first
+	Action      string `json:"action"`      // "allow" or "deny"
+	Source      string `json:"source"`      // "*", IP, CIDR block or IP range of the clients
+	Destination string `json:"destination"` // "*", domain suffix "*.example.com", CIDR, IP or host
+	Port        int    `json:"port"`        // 0 = any port
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+	Hits        int64  `json:"hits"` // matches since start, not stored
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type DBRatioAdjustment struct {
+	ID             int     `json:"id"`
+	LBAddress      string  `json:"lb_address"`
+	OldRatio       int     `json:"old_ratio"`
+	NewRatio       int     `json:"new_ratio"`
+	TargetRatio    float64 `json:"target_ratio"`    // unsmoothed ratio from the measurements
+	Throughput     float64 `json:"throughput"`      // bytes/s per active connection
+	SuccessRate    float64 `json:"success_rate"`    // percent of dials in the interval
+	LatencyMs      float64 `json:"latency_ms"`
+	CreatedAt      string  `json:"created_at"`
+}
+
 // Default configuration values
 var defaultSettings = DBSettings{
 	ListenHost:      "127.0.0.1",
@@ -75,6 +197,23 @@ var defaultSettings = DBSettings{
 	TunnelMode:      false,
 	DebugMode:       false,
 	QuietMode:       false,
+	SocksAuth:       false,
+	HTTPProxyPort:   0,
+	HTTPProxyAuth:   false,
+	LBStrategy:      "ratio",
+	LatencyToleranceMs: 20,
+	AffinityTTL:     0,
+	AffinityScope:   "domain",
+	SpilloverHysteresisPct: 10,
+	AutoTune:        false,
+	AutoTuneInterval: 60,
+	AutoTuneMinRatio: 1,
+	AutoTuneMaxRatio: 10,
+	AutoTuneSmoothing: 0.3,
+	DialMaxAttempts: 3,
+	DialTimeBudget:  15,
+	HappyEyeballs:   false,
+	HappyEyeballsDelayMs: 250,
 }
 
 var defaultGatewayConfig = DBGatewayConfig{
@@ -139,6 +278,23 @@ func createTables() error {
 		tunnel_mode BOOLEAN NOT NULL DEFAULT 0,
 		debug_mode BOOLEAN NOT NULL DEFAULT 0,
 		quiet_mode BOOLEAN NOT NULL DEFAULT 0,
+		socks_auth BOOLEAN NOT NULL DEFAULT 0,
+		http_proxy_port INTEGER NOT NULL DEFAULT 0,
+		http_proxy_auth BOOLEAN NOT NULL DEFAULT 0,
+		lb_strategy TEXT NOT NULL DEFAULT 'ratio',
+		latency_tolerance_ms INTEGER NOT NULL DEFAULT 20,
+		affinity_ttl INTEGER NOT NULL DEFAULT 0,
+		affinity_scope TEXT NOT NULL DEFAULT 'domain',
+		spillover_hysteresis_pct INTEGER NOT NULL DEFAULT 10,
+		auto_tune BOOLEAN NOT NULL DEFAULT 0,
+		auto_tune_interval INTEGER NOT NULL DEFAULT 60,
+		auto_tune_min_ratio INTEGER NOT NULL DEFAULT 1,
+		auto_tune_max_ratio INTEGER NOT NULL DEFAULT 10,
+		auto_tune_smoothing REAL NOT NULL DEFAULT 0.3,
+		dial_max_attempts INTEGER NOT NULL DEFAULT 3,
+		dial_time_budget INTEGER NOT NULL DEFAULT 15,
+		happy_eyeballs BOOLEAN NOT NULL DEFAULT 0,
+		happy_eyeballs_delay_ms INTEGER NOT NULL DEFAULT 250,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -155,6 +311,14 @@ func createTables() error {
 		success_count INTEGER DEFAULT 0,
 		failure_count INTEGER DEFAULT 0,
 		bytes_transferred INTEGER DEFAULT 0,
+		capacity_down_mbps INTEGER NOT NULL DEFAULT 0,
+		capacity_up_mbps INTEGER NOT NULL DEFAULT 0,
+		tier INTEGER NOT NULL DEFAULT 1,
+		spill_utilization_pct INTEGER NOT NULL DEFAULT 0,
+		spill_max_connections INTEGER NOT NULL DEFAULT 0,
+		cost_per_gb REAL NOT NULL DEFAULT 0,
+		cost_tier INTEGER NOT NULL DEFAULT 1,
+		accumulated_cost REAL NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -199,12 +363,126 @@ func createTables() error {
 		snapshot_time DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	// SOCKS5 proxy users table (RFC 1929 username/password authentication)
+	proxyUsersTable := `
+	CREATE TABLE IF NOT EXISTS proxy_users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Additional proxy listeners, each with its own mode
+	listenersTable := `
+	CREATE TABLE IF NOT EXISTS listeners (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		bind_host TEXT NOT NULL DEFAULT '127.0.0.1',
+		bind_port INTEGER NOT NULL,
+		mode TEXT NOT NULL DEFAULT 'socks',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT DEFAULT '',
+		is_primary BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(bind_host, bind_port)
+	);`
+
+	// Accumulated traffic cost per client over metered load balancers
+	clientCostsTable := `
+	CREATE TABLE IF NOT EXISTS client_costs (
+		source_ip TEXT PRIMARY KEY,
+		cost REAL NOT NULL DEFAULT 0,
+		metered_bytes INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Audit log of automatic contention ratio adjustments
+	ratioAdjustmentsTable := `
+	CREATE TABLE IF NOT EXISTS ratio_adjustments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		lb_address TEXT NOT NULL,
+		old_ratio INTEGER NOT NULL,
+		new_ratio INTEGER NOT NULL,
+		target_ratio REAL NOT NULL DEFAULT 0,
+		throughput REAL NOT NULL DEFAULT 0,
+		success_rate REAL NOT NULL DEFAULT 0,
+		latency_ms REAL NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Happy-eyeballs racing per destination, first match in ID order wins
+	raceRulesTable := `
+	CREATE TABLE IF NOT EXISTS race_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		destination TEXT NOT NULL,
+		port INTEGER NOT NULL DEFAULT 0,
+		race BOOLEAN NOT NULL DEFAULT 1,
+		delay_ms INTEGER NOT NULL DEFAULT 0,
+		description TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Routing by destination, applied in priority order
+	destinationRulesTable := `
+	CREATE TABLE IF NOT EXISTS destination_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		priority INTEGER NOT NULL DEFAULT 0,
+		destination TEXT NOT NULL,
+		port INTEGER NOT NULL DEFAULT 0,
+		action TEXT NOT NULL DEFAULT 'prefer',
+		lb_address TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Load balancers clients may or may not use, one policy per source pattern
+	clientPoliciesTable := `
+	CREATE TABLE IF NOT EXISTS client_policies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source TEXT NOT NULL UNIQUE,
+		mode TEXT NOT NULL DEFAULT 'allow',
+		lb_addresses TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Access control for proxy requests, first matching entry decides
+	proxyACLsTable := `
+	CREATE TABLE IF NOT EXISTS proxy_acls (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		priority INTEGER NOT NULL DEFAULT 0,
+		action TEXT NOT NULL DEFAULT 'deny',
+		source TEXT NOT NULL DEFAULT '*',
+		destination TEXT NOT NULL DEFAULT '*',
+		port INTEGER NOT NULL DEFAULT 0,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
 	tables := []string{
 		settingsTable,
 		loadBalancersTable,
 		gatewayConfigTable,
 		sourceIPRulesTable,
 		statisticsTable,
+		proxyUsersTable,
+		listenersTable,
+		clientCostsTable,
+		ratioAdjustmentsTable,
+		raceRulesTable,
+		destinationRulesTable,
+		clientPoliciesTable,
+		proxyACLsTable,
 	}
 
 	for _, table := range tables {
@@ -213,9 +491,90 @@ func createTables() error {
 		}
 	}
 
+	return migrateTables()
+}
+
+/*
+Add columns introduced after the initial schema to existing databases
+*/
+func migrateTables() error {
+	migrations := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"settings", "socks_auth", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"settings", "http_proxy_port", "INTEGER NOT NULL DEFAULT 0"},
+		{"settings", "http_proxy_auth", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"listeners", "is_primary", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"settings", "lb_strategy", "TEXT NOT NULL DEFAULT 'ratio'"},
+		{"settings", "latency_tolerance_ms", "INTEGER NOT NULL DEFAULT 20"},
+		{"settings", "affinity_ttl", "INTEGER NOT NULL DEFAULT 0"},
+		{"settings", "affinity_scope", "TEXT NOT NULL DEFAULT 'domain'"},
+		{"settings", "spillover_hysteresis_pct", "INTEGER NOT NULL DEFAULT 10"},
+		{"settings", "auto_tune", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"settings", "auto_tune_interval", "INTEGER NOT NULL DEFAULT 60"},
+		{"settings", "auto_tune_min_ratio", "INTEGER NOT NULL DEFAULT 1"},
+		{"settings", "auto_tune_max_ratio", "INTEGER NOT NULL DEFAULT 10"},
+		{"settings", "auto_tune_smoothing", "REAL NOT NULL DEFAULT 0.3"},
+		{"settings", "dial_max_attempts", "INTEGER NOT NULL DEFAULT 3"},
+		{"settings", "dial_time_budget", "INTEGER NOT NULL DEFAULT 15"},
+		{"settings", "happy_eyeballs", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"settings", "happy_eyeballs_delay_ms", "INTEGER NOT NULL DEFAULT 250"},
+		{"load_balancers", "capacity_down_mbps", "INTEGER NOT NULL DEFAULT 0"},
+		{"load_balancers", "capacity_up_mbps", "INTEGER NOT NULL DEFAULT 0"},
+		{"load_balancers", "tier", "INTEGER NOT NULL DEFAULT 1"},
+		{"load_balancers", "spill_utilization_pct", "INTEGER NOT NULL DEFAULT 0"},
+		{"load_balancers", "spill_max_connections", "INTEGER NOT NULL DEFAULT 0"},
+		{"load_balancers", "cost_per_gb", "REAL NOT NULL DEFAULT 0"},
+		{"load_balancers", "cost_tier", "INTEGER NOT NULL DEFAULT 1"},
+		{"load_balancers", "accumulated_cost", "REAL NOT NULL DEFAULT 0"},
+	}
+
+	for _, m := range migrations {
+		exists, err := columnExists(m.table, m.column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %v", m.table, err)
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", m.table, m.column, err)
+		}
+		log.Printf("[INFO] Migrated table %s: added column %s", m.table, m.column)
+	}
+
 	return nil
 }
 
+/*
+Check whether a column exists in a table
+*/
+func columnExists(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
 /*
 Initialize default data if tables are empty
 */
@@ -281,14 +640,25 @@ func loadSettings() (DBSettings, error) {
 	var settings DBSettings
 	query := `
 		SELECT id, listen_host, listen_port, web_port, config_file, 
-		       tunnel_mode, debug_mode, quiet_mode, created_at, updated_at
+		       tunnel_mode, debug_mode, quiet_mode, socks_auth, http_proxy_port, http_proxy_auth,
+		       lb_strategy, latency_tolerance_ms, affinity_ttl, affinity_scope, spillover_hysteresis_pct,
+		       auto_tune, auto_tune_interval, auto_tune_min_ratio, auto_tune_max_ratio, auto_tune_smoothing,
+		       dial_max_attempts, dial_time_budget, happy_eyeballs, happy_eyeballs_delay_ms,
+		       created_at, updated_at
 		FROM settings ORDER BY updated_at DESC LIMIT 1`
 
 	err := db.QueryRow(query).Scan(
 		&settings.ID, &settings.ListenHost, &settings.ListenPort,
 		&settings.WebPort, &settings.ConfigFile, &settings.TunnelMode,
-		&settings.DebugMode, &settings.QuietMode, &settings.CreatedAt,
-		&settings.UpdatedAt,
+		&settings.DebugMode, &settings.QuietMode, &settings.SocksAuth,
+		&settings.HTTPProxyPort, &settings.HTTPProxyAuth,
+		&settings.LBStrategy, &settings.LatencyToleranceMs,
+		&settings.AffinityTTL, &settings.AffinityScope, &settings.SpilloverHysteresisPct,
+		&settings.AutoTune, &settings.AutoTuneInterval, &settings.AutoTuneMinRatio,
+		&settings.AutoTuneMaxRatio, &settings.AutoTuneSmoothing,
+		&settings.DialMaxAttempts, &settings.DialTimeBudget,
+		&settings.HappyEyeballs, &settings.HappyEyeballsDelayMs,
+		&settings.CreatedAt, &settings.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -305,13 +675,23 @@ Save settings to database
 func saveSettings(settings DBSettings) error {
 	query := `
 		INSERT OR REPLACE INTO settings 
-		(id, listen_host, listen_port, web_port, config_file, tunnel_mode, debug_mode, quiet_mode, updated_at)
-		VALUES (1, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+		(id, listen_host, listen_port, web_port, config_file, tunnel_mode, debug_mode, quiet_mode, socks_auth,
+		 http_proxy_port, http_proxy_auth, lb_strategy, latency_tolerance_ms, affinity_ttl, affinity_scope,
+		 spillover_hysteresis_pct, auto_tune, auto_tune_interval, auto_tune_min_ratio, auto_tune_max_ratio,
+		 auto_tune_smoothing, dial_max_attempts, dial_time_budget, happy_eyeballs, happy_eyeballs_delay_ms,
+		 updated_at)
+		VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
 
 	_, err := db.Exec(query,
 		settings.ListenHost, settings.ListenPort, settings.WebPort,
 		settings.ConfigFile, settings.TunnelMode, settings.DebugMode,
-		settings.QuietMode,
+		settings.QuietMode, settings.SocksAuth,
+		settings.HTTPProxyPort, settings.HTTPProxyAuth, settings.LBStrategy,
+		settings.LatencyToleranceMs, settings.AffinityTTL, settings.AffinityScope,
+		settings.SpilloverHysteresisPct, settings.AutoTune, settings.AutoTuneInterval,
+		settings.AutoTuneMinRatio, settings.AutoTuneMaxRatio, settings.AutoTuneSmoothing,
+		settings.DialMaxAttempts, settings.DialTimeBudget,
+		settings.HappyEyeballs, settings.HappyEyeballsDelayMs,
 	)
 
 	if err != nil {
@@ -379,7 +759,8 @@ func loadLoadBalancers() ([]DBLoadBalancer, error) {
 	query := `
 		SELECT id, address, interface, contention_ratio, enabled,
 		       total_connections, success_count, failure_count, bytes_transferred,
-		       created_at, updated_at
+		       capacity_down_mbps, capacity_up_mbps, tier, spill_utilization_pct, spill_max_connections,
+		       cost_per_gb, cost_tier, accumulated_cost, created_at, updated_at
 		FROM load_balancers ORDER BY created_at ASC`
 
 	rows, err := db.Query(query)
@@ -394,7 +775,9 @@ func loadLoadBalancers() ([]DBLoadBalancer, error) {
 		err := rows.Scan(
 			&lb.ID, &lb.Address, &lb.Interface, &lb.ContentionRatio,
 			&lb.Enabled, &lb.TotalConnections, &lb.SuccessCount,
-			&lb.FailureCount, &lb.BytesTransferred, &lb.CreatedAt, &lb.UpdatedAt,
+			&lb.FailureCount, &lb.BytesTransferred, &lb.CapacityDownMbps, &lb.CapacityUpMbps,
+			&lb.Tier, &lb.SpillUtilizationPct, &lb.SpillMaxConnections,
+			&lb.CostPerGB, &lb.CostTier, &lb.AccumulatedCost, &lb.CreatedAt, &lb.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -414,12 +797,15 @@ func saveLoadBalancer(lb DBLoadBalancer) error {
 		query := `
 			INSERT INTO load_balancers 
 			(address, interface, contention_ratio, enabled, total_connections,
-			 success_count, failure_count, bytes_transferred)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+			 success_count, failure_count, bytes_transferred, capacity_down_mbps, capacity_up_mbps, tier,
+			 spill_utilization_pct, spill_max_connections, cost_per_gb, cost_tier, accumulated_cost)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 		result, err := db.Exec(query,
 			lb.Address, lb.Interface, lb.ContentionRatio, lb.Enabled,
 			lb.TotalConnections, lb.SuccessCount, lb.FailureCount, lb.BytesTransferred,
+			lb.CapacityDownMbps, lb.CapacityUpMbps, lb.Tier,
+			lb.SpillUtilizationPct, lb.SpillMaxConnections, lb.CostPerGB, lb.CostTier, lb.AccumulatedCost,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert load balancer: %v", err)
@@ -433,13 +819,17 @@ func saveLoadBalancer(lb DBLoadBalancer) error {
 			UPDATE load_balancers 
 			SET address = ?, interface = ?, contention_ratio = ?, enabled = ?,
 			    total_connections = ?, success_count = ?, failure_count = ?,
-			    bytes_transferred = ?, updated_at = CURRENT_TIMESTAMP
+			    bytes_transferred = ?, capacity_down_mbps = ?, capacity_up_mbps = ?, tier = ?,
+			    spill_utilization_pct = ?, spill_max_connections = ?,
+			    cost_per_gb = ?, cost_tier = ?, accumulated_cost = ?,
+			    updated_at = CURRENT_TIMESTAMP
 			WHERE id = ?`
 
 		_, err := db.Exec(query,
 			lb.Address, lb.Interface, lb.ContentionRatio, lb.Enabled,
 			lb.TotalConnections, lb.SuccessCount, lb.FailureCount,
-			lb.BytesTransferred, lb.ID,
+			lb.BytesTransferred, lb.CapacityDownMbps, lb.CapacityUpMbps, lb.Tier,
+			lb.SpillUtilizationPct, lb.SpillMaxConnections, lb.CostPerGB, lb.CostTier, lb.AccumulatedCost, lb.ID,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to update load balancer: %v", err)
@@ -451,6 +841,200 @@ func saveLoadBalancer(lb DBLoadBalancer) error {
 	return nil
 }
 
+/*
+Save the capacity of a load balancer
+*/
+func saveLoadBalancerCapacity(address string, downMbps int, upMbps int) error {
+	query := `
+		UPDATE load_balancers
+		SET capacity_down_mbps = ?, capacity_up_mbps = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE address = ?`
+
+	result, err := db.Exec(query, downMbps, upMbps, address)
+	if err != nil {
+		return fmt.Errorf("failed to update load balancer capacity: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("load balancer not found: %s", address)
+	}
+	return nil
+}
+
+/*
+Save the failover tier of a load balancer
+*/
+func saveLoadBalancerTier(address string, tier int) error {
+	query := `
+		UPDATE load_balancers
+		SET tier = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE address = ?`
+
+	result, err := db.Exec(query, tier, address)
+	if err != nil {
+		return fmt.Errorf("failed to update load balancer tier: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("load balancer not found: %s", address)
+	}
+	return nil
+}
+
+/*
+Save the spillover thresholds of a load balancer
+*/
+func saveLoadBalancerSpillover(address string, utilizationPct int, maxConnections int) error {
+	query := `
+		UPDATE load_balancers
+		SET spill_utilization_pct = ?, spill_max_connections = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE address = ?`
+
+	result, err := db.Exec(query, utilizationPct, maxConnections, address)
+	if err != nil {
+		return fmt.Errorf("failed to update load balancer spillover: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("load balancer not found: %s", address)
+	}
+	return nil
+}
+
+/*
+Save the cost per GB and cost tier of a load balancer
+*/
+func saveLoadBalancerCost(address string, costPerGB float64, costTier int) error {
+	query := `
+		UPDATE load_balancers
+		SET cost_per_gb = ?, cost_tier = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE address = ?`
+
+	result, err := db.Exec(query, costPerGB, costTier, address)
+	if err != nil {
+		return fmt.Errorf("failed to update load balancer cost: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("load balancer not found: %s", address)
+	}
+	return nil
+}
+
+/*
+Load accumulated client costs from database
+*/
+func loadClientCosts() ([]DBClientCost, error) {
+	query := `SELECT source_ip, cost, metered_bytes, updated_at FROM client_costs`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []DBClientCost
+	for rows.Next() {
+		var c DBClientCost
+		if err := rows.Scan(&c.SourceIP, &c.Cost, &c.MeteredBytes, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
+
+/*
+Save accumulated costs of load balancers (by address) and clients in one transaction
+*/
+func saveCostTotals(lbCosts map[string]float64, clients []DBClientCost) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for address, cost := range lbCosts {
+		if _, err := tx.Exec(`UPDATE load_balancers SET accumulated_cost = ? WHERE address = ?`, cost, address); err != nil {
+			return fmt.Errorf("failed to update load balancer cost: %v", err)
+		}
+	}
+
+	for _, c := range clients {
+		query := `
+			INSERT OR REPLACE INTO client_costs (source_ip, cost, metered_bytes, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)`
+		if _, err := tx.Exec(query, c.SourceIP, c.Cost, c.MeteredBytes); err != nil {
+			return fmt.Errorf("failed to save client cost: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+Reset accumulated costs of all load balancers and clients
+*/
+func clearCostTotals() error {
+	if _, err := db.Exec(`UPDATE load_balancers SET accumulated_cost = 0`); err != nil {
+		return fmt.Errorf("failed to reset load balancer costs: %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM client_costs`); err != nil {
+		return fmt.Errorf("failed to reset client costs: %v", err)
+	}
+	return nil
+}
+
+/*
+Append an automatic contention ratio adjustment to the audit log
+*/
+func saveRatioAdjustment(a DBRatioAdjustment) error {
+	query := `
+		INSERT INTO ratio_adjustments
+		(lb_address, old_ratio, new_ratio, target_ratio, throughput, success_rate, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := db.Exec(query, a.LBAddress, a.OldRatio, a.NewRatio, a.TargetRatio,
+		a.Throughput, a.SuccessRate, a.LatencyMs)
+	if err != nil {
+		return fmt.Errorf("failed to save ratio adjustment: %v", err)
+	}
+	return nil
+}
+
+/*
+Load the most recent automatic contention ratio adjustments, newest first
+*/
+func loadRatioAdjustments(limit int) ([]DBRatioAdjustment, error) {
+	query := `
+		SELECT id, lb_address, old_ratio, new_ratio, target_ratio, throughput, success_rate, latency_ms, created_at
+		FROM ratio_adjustments ORDER BY id DESC LIMIT ?`
+
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []DBRatioAdjustment{}
+	for rows.Next() {
+		var a DBRatioAdjustment
+		err := rows.Scan(&a.ID, &a.LBAddress, &a.OldRatio, &a.NewRatio, &a.TargetRatio,
+			&a.Throughput, &a.SuccessRate, &a.LatencyMs, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+
+	return adjustments, rows.Err()
+}
+
 /*
 Delete load balancer from database
 */
@@ -470,6 +1054,683 @@ func deleteLoadBalancer(address string) error {
 	return nil
 }
 
+/*
+Load all SOCKS5 proxy users from database
+*/
+func loadProxyUsers() ([]DBProxyUser, error) {
+	query := `
+		SELECT id, username, password_hash, enabled, description, created_at, updated_at
+		FROM proxy_users ORDER BY username ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []DBProxyUser
+	for rows.Next() {
+		var user DBProxyUser
+		err := rows.Scan(
+			&user.ID, &user.Username, &user.PasswordHash, &user.Enabled,
+			&user.Description, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+/*
+Load a single SOCKS5 proxy user by username
+*/
+func loadProxyUser(username string) (DBProxyUser, error) {
+	var user DBProxyUser
+	query := `
+		SELECT id, username, password_hash, enabled, description, created_at, updated_at
+		FROM proxy_users WHERE username = ?`
+
+	err := db.QueryRow(query, username).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.Enabled,
+		&user.Description, &user.CreatedAt, &user.UpdatedAt,
+	)
+	return user, err
+}
+
+/*
+Save SOCKS5 proxy user to database (insert or update by username).
+An empty password keeps the stored password hash of an existing user.
+*/
+func saveProxyUser(user DBProxyUser, password string) error {
+	if password != "" {
+		hash, err := hashProxyPassword(password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	}
+
+	existing, err := loadProxyUser(user.Username)
+	if err == sql.ErrNoRows {
+		if user.PasswordHash == "" {
+			return fmt.Errorf("password is required for new user %s", user.Username)
+		}
+
+		query := `
+			INSERT INTO proxy_users (username, password_hash, enabled, description)
+			VALUES (?, ?, ?, ?)`
+
+		if _, err := db.Exec(query, user.Username, user.PasswordHash, user.Enabled, user.Description); err != nil {
+			return fmt.Errorf("failed to insert proxy user: %v", err)
+		}
+
+		log.Printf("[INFO] Proxy user %s added to database", user.Username)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load proxy user: %v", err)
+	}
+
+	if user.PasswordHash == "" {
+		user.PasswordHash = existing.PasswordHash
+	}
+
+	query := `
+		UPDATE proxy_users
+		SET password_hash = ?, enabled = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	if _, err := db.Exec(query, user.PasswordHash, user.Enabled, user.Description, existing.ID); err != nil {
+		return fmt.Errorf("failed to update proxy user: %v", err)
+	}
+
+	log.Printf("[INFO] Proxy user %s updated in database", user.Username)
+	return nil
+}
+
+/*
+Delete SOCKS5 proxy user from database
+*/
+func deleteProxyUser(username string) error {
+	result, err := db.Exec("DELETE FROM proxy_users WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("failed to delete proxy user: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("proxy user not found: %s", username)
+	}
+
+	log.Printf("[INFO] Proxy user %s deleted from database", username)
+	return nil
+}
+
+/*
+Load proxy listeners from database
+*/
+func loadListeners() ([]DBListener, error) {
+	query := `
+		SELECT id, bind_host, bind_port, mode, enabled, description, is_primary, created_at, updated_at
+		FROM listeners ORDER BY id ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var listeners []DBListener
+	for rows.Next() {
+		var l DBListener
+		err := rows.Scan(
+			&l.ID, &l.BindHost, &l.BindPort, &l.Mode, &l.Enabled,
+			&l.Description, &l.Primary, &l.CreatedAt, &l.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, rows.Err()
+}
+
+/*
+Save proxy listener to database (insert when ID is 0, update otherwise).
+Returns the listener ID.
+*/
+func saveListener(l DBListener) (int, error) {
+	if l.ID == 0 {
+		query := `
+			INSERT INTO listeners (bind_host, bind_port, mode, enabled, description)
+			VALUES (?, ?, ?, ?, ?)`
+
+		result, err := db.Exec(query, l.BindHost, l.BindPort, l.Mode, l.Enabled, l.Description)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert listener: %v", err)
+		}
+
+		id, _ := result.LastInsertId()
+		log.Printf("[INFO] Listener %s:%d (%s) added to database", l.BindHost, l.BindPort, l.Mode)
+		return int(id), nil
+	}
+
+	query := `
+		UPDATE listeners
+		SET bind_host = ?, bind_port = ?, mode = ?, enabled = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	result, err := db.Exec(query, l.BindHost, l.BindPort, l.Mode, l.Enabled, l.Description, l.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update listener: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("listener not found: %d", l.ID)
+	}
+
+	log.Printf("[INFO] Listener %d updated in database", l.ID)
+	return l.ID, nil
+}
+
+/*
+Load the primary proxy listener from database
+*/
+func loadPrimaryListener() (DBListener, error) {
+	var l DBListener
+	query := `
+		SELECT id, bind_host, bind_port, mode, enabled, description, is_primary, created_at, updated_at
+		FROM listeners WHERE is_primary = 1 ORDER BY id ASC LIMIT 1`
+
+	err := db.QueryRow(query).Scan(
+		&l.ID, &l.BindHost, &l.BindPort, &l.Mode, &l.Enabled,
+		&l.Description, &l.Primary, &l.CreatedAt, &l.UpdatedAt,
+	)
+	return l, err
+}
+
+/*
+Add the primary proxy listener unless there is one. A listener already on the
+same address becomes the primary one. Returns the primary listener.
+*/
+func seedPrimaryListener(l DBListener) (DBListener, error) {
+	primary, err := loadPrimaryListener()
+	if err != sql.ErrNoRows {
+		return primary, err
+	}
+
+	query := `
+		INSERT INTO listeners (bind_host, bind_port, mode, enabled, description, is_primary)
+		VALUES (?, ?, ?, ?, ?, 1)
+		ON CONFLICT(bind_host, bind_port) DO UPDATE SET is_primary = 1`
+
+	if _, err := db.Exec(query, l.BindHost, l.BindPort, l.Mode, l.Enabled, l.Description); err != nil {
+		return DBListener{}, fmt.Errorf("failed to add primary listener: %v", err)
+	}
+
+	log.Printf("[INFO] Primary listener %s:%d (%s) added to database", l.BindHost, l.BindPort, l.Mode)
+	return loadPrimaryListener()
+}
+
+/*
+Delete proxy listener from database. The primary listener can only be disabled.
+*/
+func deleteListener(id int) error {
+	result, err := db.Exec("DELETE FROM listeners WHERE id = ? AND is_primary = 0", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete listener: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("listener %d not found or the primary listener, which can only be disabled", id)
+	}
+
+	log.Printf("[INFO] Listener %d deleted from database", id)
+	return nil
+}
+
+/*
+Load race rules from database in ID order
+*/
+func loadRaceRules() ([]DBRaceRule, error) {
+	query := `
+		SELECT id, destination, port, race, delay_ms, description, created_at, updated_at
+		FROM race_rules ORDER BY id ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []DBRaceRule
+	for rows.Next() {
+		var rule DBRaceRule
+		err := rows.Scan(
+			&rule.ID, &rule.Destination, &rule.Port, &rule.Race, &rule.DelayMs,
+			&rule.Description, &rule.CreatedAt, &rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+/*
+Save race rule to database (insert when ID is 0, update otherwise).
+Returns the rule ID.
+*/
+func saveRaceRule(rule DBRaceRule) (int, error) {
+	if rule.ID == 0 {
+		query := `
+			INSERT INTO race_rules (destination, port, race, delay_ms, description)
+			VALUES (?, ?, ?, ?, ?)`
+
+		result, err := db.Exec(query, rule.Destination, rule.Port, rule.Race, rule.DelayMs, rule.Description)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert race rule: %v", err)
+		}
+
+		id, _ := result.LastInsertId()
+		log.Printf("[INFO] Race rule for %s added to database", rule.Destination)
+		return int(id), nil
+	}
+
+	query := `
+		UPDATE race_rules
+		SET destination = ?, port = ?, race = ?, delay_ms = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	result, err := db.Exec(query, rule.Destination, rule.Port, rule.Race, rule.DelayMs, rule.Description, rule.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update race rule: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("race rule not found: %d", rule.ID)
+	}
+
+	log.Printf("[INFO] Race rule %d updated in database", rule.ID)
+	return rule.ID, nil
+}
+
+/*
+Delete race rule from database
+*/
+func deleteRaceRule(id int) error {
+	result, err := db.Exec("DELETE FROM race_rules WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete race rule: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("race rule not found: %d", id)
+	}
+
+	log.Printf("[INFO] Race rule %d deleted from database", id)
+	return nil
+}
+
+/*
+Load destination rules from database in priority order
+*/
+func loadDestinationRules() ([]DBDestinationRule, error) {
+	query := `
+		SELECT id, priority, destination, port, action, lb_address, enabled, description, created_at, updated_at
+		FROM destination_rules ORDER BY priority ASC, id ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []DBDestinationRule
+	for rows.Next() {
+		var rule DBDestinationRule
+		err := rows.Scan(
+			&rule.ID, &rule.Priority, &rule.Destination, &rule.Port, &rule.Action,
+			&rule.LBAddress, &rule.Enabled, &rule.Description, &rule.CreatedAt, &rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+/*
+Save destination rule to database (insert when ID is 0, update otherwise).
+Returns the rule ID.
+*/
+func saveDestinationRule(rule DBDestinationRule) (int, error) {
+	if rule.ID == 0 {
+		query := `
+			INSERT INTO destination_rules (priority, destination, port, action, lb_address, enabled, description)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+		result, err := db.Exec(query, rule.Priority, rule.Destination, rule.Port, rule.Action,
+			rule.LBAddress, rule.Enabled, rule.Description)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert destination rule: %v", err)
+		}
+
+		id, _ := result.LastInsertId()
+		log.Printf("[INFO] Destination rule for %s added to database", rule.Destination)
+		return int(id), nil
+	}
+
+	query := `
+		UPDATE destination_rules
+		SET priority = ?, destination = ?, port = ?, action = ?, lb_address = ?, enabled = ?, description = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	result, err := db.Exec(query, rule.Priority, rule.Destination, rule.Port, rule.Action,
+		rule.LBAddress, rule.Enabled, rule.Description, rule.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update destination rule: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("destination rule not found: %d", rule.ID)
+	}
+
+	log.Printf("[INFO] Destination rule %d updated in database", rule.ID)
+	return rule.ID, nil
+}
+
+/*
+Delete destination rule from database
+*/
+func deleteDestinationRule(id int) error {
+	result, err := db.Exec("DELETE FROM destination_rules WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete destination rule: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("destination rule not found: %d", id)
+	}
+
+	log.Printf("[INFO] Destination rule %d deleted from database", id)
+	return nil
+}
+
+/*
+Load client policies from database
+*/
+func loadClientPolicies() ([]DBClientPolicy, error) {
+	query := `
+		SELECT id, source, mode, lb_addresses, enabled, description, created_at, updated_at
+		FROM client_policies ORDER BY id ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []DBClientPolicy
+	for rows.Next() {
+		var policy DBClientPolicy
+		var lbAddresses string
+		err := rows.Scan(
+			&policy.ID, &policy.Source, &policy.Mode, &lbAddresses,
+			&policy.Enabled, &policy.Description, &policy.CreatedAt, &policy.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		policy.LBAddresses = []string{}
+		if lbAddresses != "" {
+			policy.LBAddresses = strings.Split(lbAddresses, ",")
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+/*
+Save client policy to database (insert when ID is 0, update otherwise).
+Returns the policy ID.
+*/
+func saveClientPolicy(policy DBClientPolicy) (int, error) {
+	lbAddresses := strings.Join(policy.LBAddresses, ",")
+
+	if policy.ID == 0 {
+		query := `
+			INSERT INTO client_policies (source, mode, lb_addresses, enabled, description)
+			VALUES (?, ?, ?, ?, ?)`
+
+		result, err := db.Exec(query, policy.Source, policy.Mode, lbAddresses, policy.Enabled, policy.Description)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert client policy: %v", err)
+		}
+
+		id, _ := result.LastInsertId()
+		log.Printf("[INFO] Client policy for %s added to database", policy.Source)
+		return int(id), nil
+	}
+
+	query := `
+		UPDATE client_policies
+		SET source = ?, mode = ?, lb_addresses = ?, enabled = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	result, err := db.Exec(query, policy.Source, policy.Mode, lbAddresses, policy.Enabled, policy.Description, policy.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update client policy: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("client policy not found: %d", policy.ID)
+	}
+
+	log.Printf("[INFO] Client policy %d updated in database", policy.ID)
+	return policy.ID, nil
+}
+
+/*
+Delete client policy from database
+*/
+func deleteClientPolicy(id int) error {
+	result, err := db.Exec("DELETE FROM client_policies WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete client policy: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("client policy not found: %d", id)
+	}
+
+	log.Printf("[INFO] Client policy %d deleted from database", id)
+	return nil
+}
+
+/*
+Load proxy ACL entries from database in priority order
+*/
+func loadProxyACLs() ([]DBProxyACL, error) {
+	query := `
+		SELECT id, priority, action, source, destination, port, enabled, description, created_at, updated_at
+		FROM proxy_acls ORDER BY priority ASC, id ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []DBProxyACL
+	for rows.Next() {
+		var entry DBProxyACL
+		err := rows.Scan(
+			&entry.ID, &entry.Priority, &entry.Action, &entry.Source, &entry.Destination,
+			&entry.Port, &entry.Enabled, &entry.Description, &entry.CreatedAt, &entry.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+/*
+Save proxy ACL entry to database (insert when ID is 0, update otherwise).
+Returns the entry ID.
+*/
+func saveProxyACL(entry DBProxyACL) (int, error) {
+	if entry.ID == 0 {
+		query := `
+			INSERT INTO proxy_acls (priority, action, source, destination, port, enabled, description)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+		result, err := db.Exec(query, entry.Priority, entry.Action, entry.Source, entry.Destination,
+			entry.Port, entry.Enabled, entry.Description)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert ACL entry: %v", err)
+		}
+
+		id, _ := result.LastInsertId()
+		log.Printf("[INFO] ACL entry %s %s -> %s added to database", entry.Action, entry.Source, entry.Destination)
+		return int(id), nil
+	}
+
+	query := `
+		UPDATE proxy_acls
+		SET priority = ?, action = ?, source = ?, destination = ?, port = ?, enabled = ?, description = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	result, err := db.Exec(query, entry.Priority, entry.Action, entry.Source, entry.Destination,
+		entry.Port, entry.Enabled, entry.Description, entry.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update ACL entry: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("ACL entry not found: %d", entry.ID)
+	}
+
+	log.Printf("[INFO] ACL entry %d updated in database", entry.ID)
+	return entry.ID, nil
+}
+
+/*
+Delete proxy ACL entry from database
+*/
+func deleteProxyACL(id int) error {
+	result, err := db.Exec("DELETE FROM proxy_acls WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete ACL entry: %v", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("ACL entry not found: %d", id)
+	}
+
+	log.Printf("[INFO] ACL entry %d deleted from database", id)
+	return nil
+}
+
+/*
+Check SOCKS5 credentials against the proxy_users table
+*/
+func authenticateProxyUser(username, password string) bool {
+	user, err := loadProxyUser(username)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[WARN] Failed to load proxy user %s: %v", username, err)
+		}
+		return false
+	}
+
+	return user.Enabled && verifyProxyPassword(user.PasswordHash, password)
+}
+
+/*
+Hash a proxy password with bcrypt
+*/
+func hashProxyPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// Number of verified proxy passwords remembered
+const maxVerifiedProxyPasswords = 1024
+
+// Proxy passwords verified recently, so a client opening many connections does
+// not pay for bcrypt on each one: an HMAC of the password under a key that only
+// lives in this process, keyed by the stored hash, protected by verifiedProxyPasswordsMutex
+var verifiedProxyPasswords = make(map[string][]byte)
+var verifiedProxyPasswordsKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("[ERROR] Failed to generate password cache key: %v", err)
+	}
+	return key
+}()
+var verifiedProxyPasswordsMutex sync.Mutex
+
+/*
+HMAC of a password for the verified password cache
+*/
+func proxyPasswordMAC(password string) []byte {
+	mac := hmac.New(sha256.New, verifiedProxyPasswordsKey)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+/*
+Verify a proxy password against a hash produced by hashProxyPassword
+*/
+func verifyProxyPassword(hash, password string) bool {
+	mac := proxyPasswordMAC(password)
+	verifiedProxyPasswordsMutex.Lock()
+	known, exists := verifiedProxyPasswords[hash]
+	verifiedProxyPasswordsMutex.Unlock()
+	if exists && subtle.ConstantTimeCompare(known, mac) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	verifiedProxyPasswordsMutex.Lock()
+	if len(verifiedProxyPasswords) >= maxVerifiedProxyPasswords {
+		verifiedProxyPasswords = make(map[string][]byte)
+	}
+	verifiedProxyPasswords[hash] = mac
+	verifiedProxyPasswordsMutex.Unlock()
+	return true
+}
+
 /*
 Close database connection
 */
@@ -498,6 +1759,23 @@ func syncSettingsFromDatabase() error {
 		TunnelMode:      dbSettings.TunnelMode,
 		DebugMode:       dbSettings.DebugMode,
 		QuietMode:       dbSettings.QuietMode,
+		SocksAuth:       dbSettings.SocksAuth,
+		HTTPProxyPort:   dbSettings.HTTPProxyPort,
+		HTTPProxyAuth:   dbSettings.HTTPProxyAuth,
+		LBStrategy:      dbSettings.LBStrategy,
+		LatencyToleranceMs: dbSettings.LatencyToleranceMs,
+		AffinityTTL:     dbSettings.AffinityTTL,
+		AffinityScope:   dbSettings.AffinityScope,
+		SpilloverHysteresisPct: dbSettings.SpilloverHysteresisPct,
+		AutoTune:        dbSettings.AutoTune,
+		AutoTuneInterval: dbSettings.AutoTuneInterval,
+		AutoTuneMinRatio: dbSettings.AutoTuneMinRatio,
+		AutoTuneMaxRatio: dbSettings.AutoTuneMaxRatio,
+		AutoTuneSmoothing: dbSettings.AutoTuneSmoothing,
+		DialMaxAttempts: dbSettings.DialMaxAttempts,
+		DialTimeBudget:  dbSettings.DialTimeBudget,
+		HappyEyeballs:   dbSettings.HappyEyeballs,
+		HappyEyeballsDelayMs: dbSettings.HappyEyeballsDelayMs,
 	}
 
 	// Update runtime flags
@@ -606,6 +1884,14 @@ func loadLoadBalancersFromDatabase() error {
 			failure_count:       dbLB.FailureCount,
 			enabled:             dbLB.Enabled,
 			bytes_transferred:   dbLB.BytesTransferred,
+			capacity_down_mbps:  dbLB.CapacityDownMbps,
+			capacity_up_mbps:    dbLB.CapacityUpMbps,
+			tier:                dbLB.Tier,
+			spill_utilization_pct: dbLB.SpillUtilizationPct,
+			spill_max_connections: dbLB.SpillMaxConnections,
+			cost_per_gb:         dbLB.CostPerGB,
+			cost_tier:           dbLB.CostTier,
+			accumulated_cost:    dbLB.AccumulatedCost,
 			last_traffic_update: time.Now(),
 		}
 
@@ -633,6 +1919,14 @@ func syncLoadBalancersToDatabase() error {
 			SuccessCount:      lb.success_count,
 			FailureCount:      lb.failure_count,
 			BytesTransferred:  lb.bytes_transferred,
+			CapacityDownMbps:  lb.capacity_down_mbps,
+			CapacityUpMbps:    lb.capacity_up_mbps,
+			Tier:              lb.tier,
+			SpillUtilizationPct: lb.spill_utilization_pct,
+			SpillMaxConnections: lb.spill_max_connections,
+			CostPerGB:         lb.cost_per_gb,
+			CostTier:          lb.cost_tier,
+			AccumulatedCost:   lb.accumulated_cost,
 		}
 
 		if err := saveLoadBalancer(dbLB); err != nil {
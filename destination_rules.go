@@ -0,0 +1,207 @@
+// destination_rules.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Actions of a destination rule
+var destination_rule_actions = []string{"pin", "prefer", "avoid"}
+
+// Destination rules in priority order, protected by destination_rules_mutex
+var destination_rules []DBDestinationRule
+var destination_rules_mutex sync.RWMutex
+
+/*
+Check the syntax of a destination pattern: "*", a domain suffix like
+"*.example.com", a CIDR block, an IP address or a host name
+*/
+func valid_destination_pattern(pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "*" {
+		return true
+	}
+	if strings.Contains(pattern, "/") {
+		_, _, err := net.ParseCIDR(pattern)
+		return err == nil
+	}
+	if net.ParseIP(pattern) != nil {
+		return true
+	}
+
+	pattern = strings.TrimPrefix(pattern, "*.")
+	if pattern == "" || strings.HasPrefix(pattern, ".") || strings.HasSuffix(pattern, ".") {
+		return false
+	}
+	for _, c := range pattern {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Check whether a destination pattern is a CIDR block or an IP address
+*/
+func ip_destination_pattern(pattern string) bool {
+	pattern = strings.TrimSpace(pattern)
+	return strings.Contains(pattern, "/") || net.ParseIP(pattern) != nil
+}
+
+/*
+Check whether a destination (host:port) matches a destination pattern and port
+(0 = any port). A domain suffix matches the domain itself and its subdomains;
+CIDR blocks and IP addresses only match destinations given as an IP.
+*/
+func match_destination(pattern string, port int, destination string) bool {
+	host, port_str, err := net.SplitHostPort(destination)
+	if err != nil {
+		host = destination
+	}
+	if port != 0 && port_str != strconv.Itoa(port) {
+		return false
+	}
+
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return host == pattern[2:] || strings.HasSuffix(host, pattern[1:])
+	case strings.Contains(pattern, "/"):
+		_, network, err := net.ParseCIDR(pattern)
+		ip := net.ParseIP(host)
+		return err == nil && ip != nil && network.Contains(ip)
+	}
+
+	if ip := net.ParseIP(pattern); ip != nil {
+		return ip.Equal(net.ParseIP(host))
+	}
+	return host == pattern
+}
+
+/*
+Check whether a destination rule action is supported
+*/
+func valid_destination_rule_action(action string) bool {
+	for _, a := range destination_rule_actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Load destination rules from the database into memory, lowest priority number first
+*/
+func load_destination_rules() {
+	rules, err := loadDestinationRules()
+	if err != nil {
+		log.Printf("[WARN] Failed to load destination rules from database: %v", err)
+		return
+	}
+	destination_rules_mutex.Lock()
+	destination_rules = rules
+	destination_rules_mutex.Unlock()
+}
+
+/*
+Destination rules in priority order for the web API
+*/
+func get_destination_rules() []DBDestinationRule {
+	destination_rules_mutex.RLock()
+	defer destination_rules_mutex.RUnlock()
+
+	rules := make([]DBDestinationRule, len(destination_rules))
+	copy(rules, destination_rules)
+	return rules
+}
+
+/*
+Describe a destination rule for logs and explanations
+*/
+func describe_destination_rule(rule DBDestinationRule) string {
+	destination := rule.Destination
+	if rule.Port != 0 {
+		destination = fmt.Sprintf("%s port %d", destination, rule.Port)
+	}
+	return fmt.Sprintf("destination rule %d (%s %s %s)", rule.ID, destination, rule.Action, rule.LBAddress)
+}
+
+/*
+Apply the enabled destination rules matching a destination (host:port) to
+candidate load balancers, in priority order. An avoid rule drops its load
+balancer. The first pin or prefer rule restricts the choice to its load
+balancer: a pin as long as the load balancer is a candidate at all, so no other
+link is ever used, a prefer only while it is healthy, otherwise the other
+candidates stay. Returns the remaining candidates and why the others were
+dropped. Called with mutex held.
+*/
+func select_destination_candidates(destination string, candidates []int) ([]int, map[int]string) {
+	if destination == "" {
+		return candidates, nil
+	}
+
+	destination_rules_mutex.RLock()
+	defer destination_rules_mutex.RUnlock()
+
+	var dropped map[int]string
+	drop := func(i int, reason string) {
+		if dropped == nil {
+			dropped = make(map[int]string)
+		}
+		dropped[i] = reason
+	}
+
+	for _, rule := range destination_rules {
+		if !rule.Enabled || !match_destination(rule.Destination, rule.Port, destination) {
+			continue
+		}
+
+		if rule.Action == "avoid" {
+			kept := make([]int, 0, len(candidates))
+			for _, i := range candidates {
+				if lb_list[i].address == rule.LBAddress {
+					drop(i, "avoided by "+describe_destination_rule(rule))
+				} else {
+					kept = append(kept, i)
+				}
+			}
+			candidates = kept
+			continue
+		}
+
+		target := -1
+		for _, i := range candidates {
+			if lb_list[i].address == rule.LBAddress && (rule.Action == "pin" || load_balancer_healthy(&lb_list[i])) {
+				target = i
+			}
+		}
+		if target < 0 && rule.Action == "prefer" {
+			continue
+		}
+		if target < 0 {
+			log.Printf("[WARN] %s pinned to %s, which is not available", destination, rule.LBAddress)
+		}
+
+		for _, i := range candidates {
+			if i != target {
+				drop(i, "not the link of "+describe_destination_rule(rule))
+			}
+		}
+		if target < 0 {
+			return nil, dropped
+		}
+		return []int{target}, dropped
+	}
+
+	return candidates, dropped
+}
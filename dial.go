@@ -0,0 +1,303 @@
+// dial.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"time"
+)
+
+// Dials a single TCP connection from a local IP of a load balancer
+type link_dial_func func(ctx context.Context, network string, local_ip net.IP, remote_address string) (net.Conn, error)
+
+/*
+Returns "tcp4" or "tcp6" for an IP address
+*/
+func tcp_network_for_ip(ip net.IP) string {
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+/*
+Find a usable address of the given family on an interface.
+IPv6 link-local addresses are skipped since they need a zone to be dialed from.
+*/
+func get_iface_address(iface_name string, want_ipv6 bool) net.IP {
+	if iface_name == "" {
+		return nil
+	}
+
+	iface, err := net.InterfaceByName(iface_name)
+	if err != nil {
+		return nil
+	}
+
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if (ipnet.IP.To4() == nil) == want_ipv6 {
+			return ipnet.IP
+		}
+	}
+	return nil
+}
+
+/*
+Local addresses a load balancer can dial from: its configured address first,
+then an address of the other family on the same interface, if there is one.
+*/
+func load_balancer_local_ips(load_balancer *enhanced_load_balancer) []net.IP {
+	primary := net.ParseIP(load_balancer.address)
+	if primary == nil {
+		return nil
+	}
+
+	local_ips := []net.IP{primary}
+
+	iface := load_balancer.iface
+	if iface == "" {
+		iface = get_iface_from_ip(load_balancer.address)
+	}
+	if other := get_iface_address(iface, primary.To4() != nil); other != nil {
+		local_ips = append(local_ips, other)
+	}
+	return local_ips
+}
+
+/*
+Dial a remote address over a load balancer, choosing the address family per link.
+The link's own family is tried first; when the destination also has addresses of
+the other family and the link's interface has one too, that family is the fallback.
+The connect time of the successful attempt is recorded for the load balancer.
+*/
+func dial_with_family_fallback(ctx context.Context, load_balancer *enhanced_load_balancer, remote_address string, dial link_dial_func) (net.Conn, error) {
+	local_ips := load_balancer_local_ips(load_balancer)
+	if len(local_ips) == 0 {
+		return nil, fmt.Errorf("invalid local IP %s", load_balancer.address)
+	}
+
+	host, port, err := net.SplitHostPort(remote_address)
+	if err != nil {
+		return nil, err
+	}
+
+	var remote_ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		remote_ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			remote_ips = append(remote_ips, addr.IP)
+		}
+	}
+
+	var last_err error
+	for _, local_ip := range local_ips {
+		network := tcp_network_for_ip(local_ip)
+
+		for _, remote_ip := range remote_ips {
+			if tcp_network_for_ip(remote_ip) != network {
+				continue
+			}
+
+			dial_start := time.Now()
+			conn, err := dial(ctx, network, local_ip, net.JoinHostPort(remote_ip.String(), port))
+			if err == nil {
+				record_connect_latency(load_balancer, time.Since(dial_start))
+				return conn, nil
+			}
+			last_err = err
+
+			// Do not keep trying once the caller gave up
+			if ctx.Err() != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if last_err == nil {
+		last_err = fmt.Errorf("no common address family between %s and %s", load_balancer.address, host)
+	}
+	return nil, last_err
+}
+
+/*
+Check whether a dial error points at the link rather than the destination.
+A refused connection or a failed name lookup would fail over any other link too.
+*/
+func is_link_failure(err error) bool {
+	var dns_error *net.DNSError
+	return dial_error_reply(err) != CONNECTION_REFUSED && !errors.As(err, &dns_error)
+}
+
+/*
+Outcome of dialing a remote address through the load balancers. After a failure
+the load balancer is the last one tried and reply the reply code for its error.
+*/
+type dial_outcome struct {
+	conn          net.Conn
+	load_balancer *enhanced_load_balancer
+	index         int
+	reply         byte
+	race          *race_result // set when a second load balancer was raced against the first
+}
+
+// Result of a single dial over one load balancer
+type dial_attempt struct {
+	conn          net.Conn
+	load_balancer *enhanced_load_balancer
+	index         int
+	attempt       int
+	err           error
+}
+
+/*
+Dial a remote address for a source IP over the load balancer chosen for it.
+When a dial fails for a link reason the next load balancer is tried, up to
+dial_max_attempts load balancers within dial_time_budget seconds; each attempt
+gets an equal share of the remaining time so one hanging link leaves time for
+the others. Every failed attempt is counted on its load balancer.
+When racing is on for the destination and a dial has not connected after the
+race delay, the next load balancer is dialed in parallel and the first
+connection wins; the other one is closed.
+*/
+func dial_with_retry(source_ip string, remote_address string) (dial_outcome, error) {
+	max_attempts := currentSettings.DialMaxAttempts
+	if max_attempts < 1 {
+		max_attempts = 1
+	}
+	budget := time.Duration(currentSettings.DialTimeBudget) * time.Second
+	if budget <= 0 {
+		budget = time.Duration(defaultSettings.DialTimeBudget) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+
+	// Buffered for every attempt so dials that lose a race never block
+	results := make(chan dial_attempt, max_attempts)
+	tried := new(big.Int)
+	attempts, pending := 0, 0
+	dial := func(load_balancer *enhanced_load_balancer, i int) {
+		attempts++
+		pending++
+		tried.SetBit(tried, i, 1)
+		deadline, _ := ctx.Deadline()
+		remaining := max_attempts - attempts + 1
+		if remaining < 1 {
+			remaining = 1
+		}
+		attempt_ctx, attempt_cancel := context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+		go func(attempt int) {
+			defer attempt_cancel()
+			remote_conn, err := dial_via_load_balancer(attempt_ctx, load_balancer, remote_address)
+			results <- dial_attempt{remote_conn, load_balancer, i, attempt, err}
+		}(attempts)
+	}
+	// Next load balancer that was not dialed yet for this connection
+	next := func(i int) (*enhanced_load_balancer, int, bool) {
+		load_balancer, j, err := get_enhanced_load_balancer(source_ip, i, tried, remote_address)
+		return load_balancer, j, err == nil && tried.Bit(j) == 0
+	}
+
+	start := time.Now()
+	load_balancer, i, err := get_enhanced_load_balancer(source_ip, remote_address)
+	if err != nil {
+		return dial_outcome{index: -1, reply: dial_error_reply(err)}, err
+	}
+	dial(load_balancer, i)
+
+	race_delay, race := get_race_delay(remote_address)
+	var race_timer *time.Timer
+	var race_fired <-chan time.Time
+	var raced []*enhanced_load_balancer
+	if race && max_attempts > 1 {
+		race_timer = time.NewTimer(race_delay)
+		defer race_timer.Stop()
+		race_fired = race_timer.C
+		raced = append(raced, load_balancer)
+	}
+
+	last := dial_attempt{load_balancer: load_balancer, index: i}
+	var reply byte
+	for pending > 0 {
+		select {
+		case <-race_fired:
+			race_fired = nil
+			if attempts >= max_attempts {
+				// Retries already used up every attempt
+				continue
+			}
+			if next_lb, j, ok := next(last.index); ok {
+				log.Printf("[DEBUG] %s not connected via %s after %v, racing LB %d (%s)",
+					remote_address, raced[0].address, race_delay, j, next_lb.address)
+				raced = append(raced, next_lb)
+				dial(next_lb, j)
+			}
+
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				go close_lost_dials(results, pending)
+				outcome := dial_outcome{conn: result.conn, load_balancer: result.load_balancer, index: result.index, reply: SUCCESS}
+				if len(raced) == 2 {
+					outcome.race = &race_result{Winner: result.load_balancer.address, Delay: race_delay, ConnectTime: time.Since(start)}
+					for _, lb := range raced {
+						if lb != result.load_balancer {
+							outcome.race.Loser = lb.address
+						}
+					}
+					// The racing dial pinned its load balancer, the winner keeps the sticky session
+					pin_affinity(source_ip, remote_address, result.load_balancer)
+				}
+				return outcome, nil
+			}
+
+			last = result
+			reply = record_load_balancer_failure(result.load_balancer, result.err)
+			log.Printf("[WARN] %s -> %s via %s {%s} LB: %d, Source: %s, attempt %d/%d",
+				remote_address, result.load_balancer.address, result.load_balancer.iface, result.err, result.index, source_ip, result.attempt, max_attempts)
+
+			if attempts >= max_attempts && race_fired != nil {
+				// No attempt left to race with
+				race_timer.Stop()
+				race_fired = nil
+			}
+
+			// A racing dial may still connect
+			if pending > 0 || attempts >= max_attempts || !is_link_failure(result.err) || ctx.Err() != nil {
+				continue
+			}
+			next_lb, j, ok := next(result.index)
+			if !ok {
+				// Every enabled load balancer has been tried
+				continue
+			}
+			if race_fired != nil {
+				// Not raced yet: the race delay starts again for the next load balancer
+				if !race_timer.Stop() {
+					select {
+					case <-race_timer.C:
+					default:
+					}
+				}
+				race_timer.Reset(race_delay)
+				raced[0] = next_lb
+			}
+			dial(next_lb, j)
+		}
+	}
+
+	return dial_outcome{load_balancer: last.load_balancer, index: last.index, reply: reply}, last.err
+}
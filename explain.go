@@ -0,0 +1,190 @@
+// explain.go
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+State of a load balancer as seen by the selection for one source IP
+*/
+type load_balancer_explanation struct {
+	Index               int             `json:"index"`
+	Address             string          `json:"address"`
+	Enabled             bool            `json:"enabled"`
+	Healthy             bool            `json:"healthy"`
+	ConsecutiveFailures int             `json:"consecutive_failures"`
+	Tier                int             `json:"tier"`
+	CostTier            int             `json:"cost_tier"`
+	Spilling            bool            `json:"spilling"`
+	ContentionRatio     int             `json:"contention_ratio"` // configured ratio
+	EffectiveRatio      int             `json:"effective_ratio"`  // ratio used for this source IP
+	Rule                *source_ip_rule `json:"rule,omitempty"`   // source IP rule that matched
+	SourceCounter       int             `json:"source_counter"`   // connections of the current burst for this source IP
+	ActiveConnections   int64           `json:"active_connections"`
+	Candidate           bool            `json:"candidate"`         // still in the choice when the strategy picks
+	Skipped             string          `json:"skipped,omitempty"` // why it is not a candidate
+}
+
+/*
+Which load balancer the next connection of a source IP would use and why
+*/
+type selection_explanation struct {
+	SourceIP      string                      `json:"source_ip"`
+	Destination   string                      `json:"destination,omitempty"`
+	Strategy      string                      `json:"strategy"`
+	ClientPolicy  *DBClientPolicy             `json:"client_policy,omitempty"` // client policy that matched
+	Selected      string                      `json:"selected"`                // empty when the choice is random or refused
+	SelectedIndex int                         `json:"selected_index"`          // -1 when the choice is random or refused
+	Reason        string                      `json:"reason"`
+	ActiveTier    int                         `json:"active_tier"`
+	SourceIndex   int                         `json:"source_index"` // round robin position of the source IP, -1 = global
+	GlobalIndex   int                         `json:"global_index"`
+	LoadBalancers []load_balancer_explanation `json:"load_balancers"`
+}
+
+/*
+Explain the choice get_enhanced_load_balancer would make for the next
+connection of a source IP to a destination (may be empty, then destination
+rules and sticky sessions are not considered), step by step, without
+consuming a slot: no counters, round robin positions or sticky sessions change.
+*/
+func explain_load_balancer_selection(source_ip string, destination string) selection_explanation {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	explanation := selection_explanation{
+		SourceIP:      source_ip,
+		Destination:   destination,
+		Strategy:      get_source_ip_strategy(source_ip),
+		SelectedIndex: -1,
+		ActiveTier:    active_tier,
+		SourceIndex:   -1,
+		GlobalIndex:   lb_index,
+	}
+	if i, exists := source_lb_indices[source_ip]; exists {
+		explanation.SourceIndex = i
+	}
+	if policy, exists := match_client_policy(source_ip); exists {
+		explanation.ClientPolicy = &policy
+	}
+
+	candidates := make([]int, 0, len(lb_list))
+	for i := range lb_list {
+		lb := &lb_list[i]
+		info := load_balancer_explanation{
+			Index:               i,
+			Address:             lb.address,
+			Enabled:             lb.enabled,
+			Healthy:             load_balancer_healthy(lb),
+			ConsecutiveFailures: lb.consecutive_failures,
+			Tier:                lb.tier,
+			CostTier:            lb.cost_tier,
+			Spilling:            peek_spillover_state(lb),
+			ContentionRatio:     lb.contention_ratio,
+			EffectiveRatio:      get_effective_contention_ratio(lb, source_ip),
+			SourceCounter:       lb.source_ip_counters[source_ip],
+			ActiveConnections:   atomic.LoadInt64(&lb.active_count),
+		}
+		if rule, exists := get_source_ip_rule(lb, source_ip); exists {
+			info.Rule = &rule
+		}
+		if lb.enabled {
+			candidates = append(candidates, i)
+		} else {
+			info.Skipped = "disabled"
+		}
+		explanation.LoadBalancers = append(explanation.LoadBalancers, info)
+	}
+
+	// Mark load balancers a selection step dropped from the candidates
+	skip := func(kept []int, reason func(lb *enhanced_load_balancer) string) {
+		for _, i := range candidates {
+			if !contains_index(kept, i) {
+				explanation.LoadBalancers[i].Skipped = reason(&lb_list[i])
+			}
+		}
+		candidates = kept
+	}
+	selected := func(i int, reason string) selection_explanation {
+		explanation.Selected, explanation.SelectedIndex, explanation.Reason = lb_list[i].address, i, reason
+		for _, c := range candidates {
+			explanation.LoadBalancers[c].Candidate = true
+		}
+		return explanation
+	}
+
+	available := len(candidates)
+	kept, dropped := select_client_candidates(source_ip, candidates)
+	for i, reason := range dropped {
+		explanation.LoadBalancers[i].Skipped = reason
+	}
+	candidates = kept
+	if len(candidates) == 0 && available > 0 {
+		explanation.Reason = "no load balancer is allowed for this client, the connection is refused"
+		return explanation
+	}
+
+	kept, dropped = select_destination_candidates(destination, candidates)
+	for i, reason := range dropped {
+		explanation.LoadBalancers[i].Skipped = reason
+	}
+	candidates = kept
+
+	if len(candidates) == 0 {
+		explanation.Reason = "no load balancer is available, the connection is refused"
+		return explanation
+	}
+
+	tiered, probe := get_tier_candidates(candidates)
+	if probe >= 0 {
+		skip([]int{probe}, func(lb *enhanced_load_balancer) string {
+			return "a better tier is due for a probe"
+		})
+		return selected(probe, fmt.Sprintf("probe of unhealthy tier %d after %d failures", lb_list[probe].tier, lb_list[probe].consecutive_failures))
+	}
+	skip(tiered, func(lb *enhanced_load_balancer) string {
+		if !load_balancer_healthy(lb) {
+			return fmt.Sprintf("unhealthy after %d failures in a row", lb.consecutive_failures)
+		}
+		return fmt.Sprintf("tier %d is not the active tier", lb.tier)
+	})
+
+	skip(peek_cost_candidates(candidates), func(lb *enhanced_load_balancer) string {
+		if peek_spillover_state(lb) {
+			return "spilling over"
+		}
+		return fmt.Sprintf("cost tier %d while a cheaper link has room", lb.cost_tier)
+	})
+
+	if destination != "" && currentSettings.AffinityTTL > 0 {
+		affinity_destination := get_affinity_destination(destination)
+		if i := peek_affinity(source_ip, affinity_destination, candidates); i >= 0 {
+			return selected(i, "sticky session for "+affinity_destination)
+		}
+	}
+
+	skip(peek_spillover_candidates(candidates), func(lb *enhanced_load_balancer) string {
+		if peek_spillover_state(lb) {
+			return "spilling over"
+		}
+		return "waiting for the links before it to spill over"
+	})
+
+	i := lb_strategies[explanation.Strategy].peek(source_ip, candidates)
+	if i < 0 {
+		for _, c := range candidates {
+			explanation.LoadBalancers[c].Candidate = true
+		}
+		explanation.Reason = fmt.Sprintf("strategy %s picks randomly among the candidates by effective ratio", explanation.Strategy)
+		return explanation
+	}
+
+	reason := fmt.Sprintf("strategy %s", explanation.Strategy)
+	if explanation.Strategy == "ratio" {
+		lb := &lb_list[i]
+		reason = fmt.Sprintf("strategy ratio: connection %d of %d in the current burst", lb.source_ip_counters[source_ip]+1, get_effective_contention_ratio(lb, source_ip))
+	}
+	return selected(i, reason)
+}
@@ -0,0 +1,430 @@
+// http_proxy.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Realm announced in Proxy-Authenticate challenges
+const http_proxy_realm = "go-dispatch-proxy"
+
+/*
+Connection whose first bytes were already consumed by a buffered reader.
+Reads drain the buffer before falling through to the socket.
+*/
+type buffered_conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *buffered_conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+/*
+Start the HTTP proxy listener (CONNECT tunnelling and plain HTTP forwarding)
+*/
+func start_http_proxy(address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Printf("[ERROR] Failed to start HTTP proxy on %s: %v", address, err)
+		return
+	}
+	defer listener.Close()
+
+	log.Printf("[INFO] HTTP proxy started on %s", address)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("[WARN] Failed to accept HTTP proxy connection: %v", err)
+			continue
+		}
+
+		go handle_connection(conn, "http")
+	}
+}
+
+/*
+Sends a minimal HTTP response and closes the client connection
+*/
+func http_proxy_error(conn net.Conn, code int, extra_headers string) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n%sContent-Length: 0\r\nConnection: close\r\n\r\n",
+		code, http.StatusText(code), extra_headers)
+	conn.Close()
+}
+
+/*
+Checks the Proxy-Authorization header against the proxy users.
+Returns the username and whether the request may proceed.
+*/
+func http_proxy_authenticate(req *http.Request) (string, bool) {
+	if !currentSettings.HTTPProxyAuth {
+		return "", true
+	}
+
+	scheme, credentials, found := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", false
+	}
+
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found || !authenticateProxyUser(username, password) {
+		log.Printf("[WARN] HTTP proxy authentication failed for user %q", username)
+		return "", false
+	}
+	return username, true
+}
+
+/*
+Builds the response to a CONNECT request from a SOCKS5 status code
+*/
+func build_http_connect_reply(status byte) []byte {
+	var code int
+	switch status {
+	case SUCCESS:
+		return []byte("HTTP/1.1 200 Connection established\r\n\r\n")
+	case CONNECTION_NOT_ALLOWED:
+		code = http.StatusForbidden
+	case TTL_EXPIRED:
+		code = http.StatusGatewayTimeout
+	case COMMAND_NOT_SUPPORTED, ADDRTYPE_NOT_SUPPORTED:
+		code = http.StatusNotImplemented
+	default:
+		code = http.StatusBadGateway
+	}
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		code, http.StatusText(code)))
+}
+
+/*
+Handle a client of the HTTP proxy listener. CONNECT requests are tunnelled
+through the load balancer chosen for the client's source IP, absolute-URI
+requests are forwarded one by one while the client keeps the connection alive.
+*/
+func handle_http_connection(conn net.Conn) {
+	source_ip := get_source_ip(conn)
+	reader := bufio.NewReader(conn)
+	timeout := handshake_timeout
+
+	for {
+		conn.SetDeadline(time.Now().Add(timeout))
+		timeout = idle_timeout
+
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if debug_mode && err != io.EOF {
+				log.Printf("[DEBUG] HTTP proxy request from %s failed: %v", source_ip, err)
+			}
+			conn.Close()
+			return
+		}
+
+		username, ok := http_proxy_authenticate(req)
+		if !ok {
+			http_proxy_error(conn, http.StatusProxyAuthRequired,
+				fmt.Sprintf("Proxy-Authenticate: Basic realm=%q\r\n", http_proxy_realm))
+			return
+		}
+
+		conn.SetDeadline(time.Time{})
+
+		if req.Method == http.MethodConnect {
+			address := req.Host
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				address = net.JoinHostPort(strings.Trim(address, "[]"), "443")
+			}
+
+			if debug_mode {
+				log.Printf("[DEBUG] HTTP CONNECT from %s -> %s", source_ip, address)
+			}
+
+			request := &proxy_request{protocol: "HTTP", command: CONNECT, address: address, username: username}
+			if !check_proxy_acl(source_ip, address, request.protocol) {
+				request.reply(conn, CONNECTION_NOT_ALLOWED, nil)
+				conn.Close()
+				return
+			}
+			enhanced_server_response(&buffered_conn{Conn: conn, reader: reader}, request, source_ip)
+			return
+		}
+
+		if !forward_http_request(conn, req, username, source_ip) {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Value added to the Via header of forwarded requests and responses
+const http_proxy_via = "1.1 go-dispatch-proxy"
+
+// Headers that only apply to a single hop (RFC 9110, section 7.6.1)
+var http_hop_by_hop_headers = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Upstream transports, one per load balancer so pooled connections stay on their uplink
+var http_transports = make(map[string]*http.Transport)
+var http_transports_mutex sync.Mutex
+
+/*
+Error of dialing an origin through a load balancer, as opposed to an error of
+the origin itself once connected
+*/
+type http_dial_error struct {
+	err error
+}
+
+func (e *http_dial_error) Error() string { return e.err.Error() }
+func (e *http_dial_error) Unwrap() error { return e.err }
+
+/*
+Returns the transport dialing through a load balancer, creating it on first use.
+The load balancer is looked up by address on every dial, since lb_list may have
+been reallocated or changed since the transport was created.
+*/
+func get_http_transport(load_balancer *enhanced_load_balancer) *http.Transport {
+	address := load_balancer.address
+	key := address + "|" + load_balancer.iface
+
+	http_transports_mutex.Lock()
+	defer http_transports_mutex.Unlock()
+
+	if transport, exists := http_transports[key]; exists {
+		return transport
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, remote_address string) (net.Conn, error) {
+			load_balancer, err := find_http_load_balancer(address)
+			if err != nil {
+				return nil, &http_dial_error{err}
+			}
+			conn, err := dial_via_load_balancer(ctx, load_balancer, remote_address)
+			if err != nil {
+				return nil, &http_dial_error{err}
+			}
+			return conn, nil
+		},
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: connection_timeout,
+		DisableCompression:    true, // pass Accept-Encoding through untouched
+	}
+	http_transports[key] = transport
+	return transport
+}
+
+/*
+Current load balancer with an address, for transports dialing through it
+*/
+func find_http_load_balancer(address string) (*enhanced_load_balancer, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range lb_list {
+		if lb_list[i].address == address {
+			return &lb_list[i], nil
+		}
+	}
+	return nil, fmt.Errorf("load balancer %s was removed", address)
+}
+
+/*
+Drop the transports of a removed load balancer and close their idle connections
+*/
+func drop_http_transports(address string) {
+	http_transports_mutex.Lock()
+	defer http_transports_mutex.Unlock()
+
+	for key, transport := range http_transports {
+		if strings.HasPrefix(key, address+"|") {
+			transport.CloseIdleConnections()
+			delete(http_transports, key)
+		}
+	}
+}
+
+/*
+Remove hop-by-hop headers, including the ones named in the Connection header
+*/
+func strip_hop_by_hop_headers(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range http_hop_by_hop_headers {
+		header.Del(name)
+	}
+}
+
+/*
+Append this proxy to the Via header
+*/
+func add_via_header(header http.Header) {
+	if via := header.Get("Via"); via != "" {
+		header.Set("Via", via+", "+http_proxy_via)
+	} else {
+		header.Set("Via", http_proxy_via)
+	}
+}
+
+/*
+Reader and writer wrappers accounting forwarded bytes to a tracked connection
+*/
+type monitored_reader struct {
+	reader  io.ReadCloser
+	conn_id string
+}
+
+func (r *monitored_reader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	if n > 0 {
+		update_connection_traffic(r.conn_id, 0, int64(n))
+	}
+	return n, err
+}
+
+func (r *monitored_reader) Close() error {
+	return r.reader.Close()
+}
+
+type monitored_writer struct {
+	writer  io.Writer
+	conn_id string
+}
+
+func (w *monitored_writer) Write(b []byte) (int, error) {
+	n, err := w.writer.Write(b)
+	if n > 0 {
+		update_connection_traffic(w.conn_id, int64(n), 0)
+	}
+	return n, err
+}
+
+/*
+Forward an absolute-URI request over the load balancer chosen for the source IP.
+Every request is tracked as its own connection so the history shows which
+uplink served which site. Returns whether the client connection can be reused.
+*/
+func forward_http_request(conn net.Conn, req *http.Request, username string, source_ip string) bool {
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		http_proxy_error(conn, http.StatusBadRequest, "")
+		return false
+	}
+
+	host := req.URL.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	}
+
+	if !check_proxy_acl(source_ip, host, "HTTP") {
+		http_proxy_error(conn, http.StatusForbidden, "")
+		return false
+	}
+
+	load_balancer, i, err := get_enhanced_load_balancer(source_ip, host)
+	if err != nil {
+		status := http.StatusBadGateway
+		if dial_error_reply(err) == CONNECTION_NOT_ALLOWED {
+			status = http.StatusForbidden
+		}
+		http_proxy_error(conn, status, "")
+		return false
+	}
+
+	conn_id := add_active_connection(conn, host, load_balancer, i, username)
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.Protocol = "HTTP"
+		ac.HTTPMethod = req.Method
+		ac.HTTPHost = req.URL.Host
+	})
+	defer remove_active_connection(conn_id)
+
+	// Outgoing request: client request without proxy specific headers.
+	// HTTP/1.0 clients cannot frame chunked responses, so they get one request per connection.
+	keep_alive := !req.Close && req.ProtoAtLeast(1, 1)
+	out := req.Clone(context.Background())
+	out.RequestURI = ""
+	out.Close = false
+	strip_hop_by_hop_headers(out.Header)
+	add_via_header(out.Header)
+	if _, ok := out.Header["User-Agent"]; !ok {
+		out.Header.Set("User-Agent", "") // do not add Go's default User-Agent
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		out.Body = &monitored_reader{reader: req.Body, conn_id: conn_id}
+	}
+
+	resp, err := get_http_transport(load_balancer).RoundTrip(out)
+	if err != nil {
+		// Only a failed dial counts against the link, not a slow or broken origin
+		record := record_peer_failure
+		var dial_err *http_dial_error
+		if errors.As(err, &dial_err) {
+			record = record_load_balancer_failure
+		}
+		status := http.StatusBadGateway
+		if record(load_balancer, err) == TTL_EXPIRED {
+			status = http.StatusGatewayTimeout
+		}
+		log.Printf("[WARN] HTTP %s %s via %s {%s} LB: %d, Source: %s",
+			req.Method, req.URL.Host, load_balancer.address, err, i, source_ip)
+		update_active_connection(conn_id, func(ac *active_connection) {
+			ac.HTTPStatus = status
+		})
+		http_proxy_error(conn, status, "")
+		return false
+	}
+	defer resp.Body.Close()
+
+	load_balancer.success_count++
+
+	strip_hop_by_hop_headers(resp.Header)
+	add_via_header(resp.Header)
+	resp.ProtoMajor, resp.ProtoMinor = req.ProtoMajor, req.ProtoMinor
+	resp.Close = !keep_alive
+
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.HTTPStatus = resp.StatusCode
+	})
+
+	err = resp.Write(&monitored_writer{writer: conn, conn_id: conn_id})
+
+	bytes_in, bytes_out := int64(0), int64(0)
+	update_active_connection(conn_id, func(ac *active_connection) {
+		bytes_in, bytes_out = ac.BytesIn, ac.BytesOut
+	})
+	log.Printf("[DEBUG] HTTP %s %s -> %d (%d bytes in, %d bytes out) via %s LB: %d, Source: %s",
+		req.Method, req.URL.Host, resp.StatusCode, bytes_in, bytes_out, load_balancer.address, i, source_ip)
+
+	return err == nil && keep_alive
+}
@@ -0,0 +1,204 @@
+// listeners.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Modes a configured listener can serve
+var listener_modes = []string{"socks", "tunnel", "http", "auto"}
+
+// A running listener from the listeners table
+type proxy_listener struct {
+	config   DBListener
+	listener net.Listener
+}
+
+// Running listeners by listener ID, and the last start error of failed ones
+var running_listeners = make(map[int]*proxy_listener)
+var listener_errors = make(map[int]string)
+var listeners_mutex sync.Mutex
+
+/*
+Check whether a listener mode is supported
+*/
+func valid_listener_mode(mode string) bool {
+	for _, m := range listener_modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Accept connections on a listener and hand them to the handler of its mode
+*/
+func serve_listener(pl *proxy_listener) {
+	for {
+		conn, err := pl.listener.Accept()
+		if err != nil {
+			// Closed by stop_listener
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[WARN] Listener %d could not accept connection: %v", pl.config.ID, err)
+			continue
+		}
+
+		go handle_connection(conn, pl.config.Mode)
+	}
+}
+
+/*
+Start a configured listener. A listener already running with the same ID is
+stopped first so changed settings take effect.
+*/
+func start_listener(config DBListener) error {
+	// Held from stopping until the new listener is registered, so concurrent
+	// starts of the same listener cannot both bind
+	listeners_mutex.Lock()
+	defer listeners_mutex.Unlock()
+
+	stop_running_listener(config.ID)
+
+	address := net.JoinHostPort(config.BindHost, strconv.Itoa(config.BindPort))
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		listener_errors[config.ID] = err.Error()
+		return fmt.Errorf("could not listen on %s: %v", address, err)
+	}
+	delete(listener_errors, config.ID)
+
+	pl := &proxy_listener{config: config, listener: l}
+	running_listeners[config.ID] = pl
+	go serve_listener(pl)
+
+	log.Printf("[INFO] Listener %d (%s) started on %s", config.ID, config.Mode, address)
+	return nil
+}
+
+/*
+Stop a running listener. Established connections are not affected.
+*/
+func stop_listener(id int) {
+	listeners_mutex.Lock()
+	defer listeners_mutex.Unlock()
+
+	stop_running_listener(id)
+}
+
+/*
+Stop a running listener. Called with listeners_mutex held.
+*/
+func stop_running_listener(id int) {
+	delete(listener_errors, id)
+	if pl, exists := running_listeners[id]; exists {
+		pl.listener.Close()
+		delete(running_listeners, id)
+		log.Printf("[INFO] Listener %d (%s) stopped", id, pl.config.Mode)
+	}
+}
+
+/*
+Start or stop a listener according to its enabled flag
+*/
+func apply_listener(config DBListener) error {
+	if !config.Enabled {
+		stop_listener(config.ID)
+		return nil
+	}
+	return start_listener(config)
+}
+
+/*
+Start all enabled listeners from the database
+*/
+func start_configured_listeners() {
+	listeners, err := loadListeners()
+	if err != nil {
+		log.Printf("[WARN] Failed to load listeners from database: %v", err)
+		return
+	}
+
+	for _, l := range listeners {
+		if err := apply_listener(l); err != nil {
+			log.Printf("[WARN] Listener %d: %v", l.ID, err)
+		}
+	}
+}
+
+/*
+Add the primary listener from the listen settings when the listeners table has
+none yet, otherwise take the listen settings from it
+*/
+func init_primary_listener() {
+	mode := "socks"
+	if currentSettings.TunnelMode {
+		mode = "tunnel"
+	}
+
+	primary, err := seedPrimaryListener(DBListener{
+		BindHost:    currentSettings.ListenHost,
+		BindPort:    currentSettings.ListenPort,
+		Mode:        mode,
+		Enabled:     true,
+		Description: "Primary listener",
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to set up the primary listener: %v", err)
+		return
+	}
+	sync_primary_listen_settings(primary)
+}
+
+/*
+Mirror the primary listener in the listen settings
+*/
+func sync_primary_listen_settings(primary DBListener) {
+	currentSettings.ListenHost = primary.BindHost
+	currentSettings.ListenPort = primary.BindPort
+	currentSettings.TunnelMode = primary.Mode == "tunnel"
+}
+
+/*
+Move the primary listener to changed listen settings and restart it
+*/
+func update_primary_listener(host string, port int, tunnel bool) error {
+	primary, err := loadPrimaryListener()
+	if err != nil {
+		return fmt.Errorf("could not load the primary listener: %v", err)
+	}
+
+	primary.BindHost, primary.BindPort = host, port
+	if tunnel {
+		primary.Mode = "tunnel"
+	} else if primary.Mode == "tunnel" {
+		primary.Mode = "socks"
+	}
+	if _, err := saveListener(primary); err != nil {
+		return err
+	}
+	return apply_listener(primary)
+}
+
+/*
+Runtime state of a listener for the web API: "running", "stopped" or "error"
+*/
+func get_listener_status(id int) (string, string) {
+	listeners_mutex.Lock()
+	defer listeners_mutex.Unlock()
+
+	if _, exists := running_listeners[id]; exists {
+		return "running", ""
+	}
+	if err, exists := listener_errors[id]; exists {
+		return "error", err
+	}
+	return "stopped", ""
+}
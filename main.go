@@ -2,7 +2,10 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -15,6 +18,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -43,6 +47,7 @@ type source_ip_rule struct {
 	SourceIP         string `json:"source_ip"`
 	ContentionRatio  int    `json:"contention_ratio"`
 	Description      string `json:"description"`
+	Strategy         string `json:"strategy,omitempty"` // Load-balancing strategy for this source IP
 }
 
 // Real-time connection tracking structure
@@ -60,6 +65,14 @@ type active_connection struct {
 	BytesOut        int64     `json:"bytes_out"`
 	Status          string    `json:"status"` // "active", "closing", "closed"
 	Protocol        string    `json:"protocol"`
+	Username        string    `json:"username,omitempty"`      // Authenticated proxy user
+	HTTPMethod      string    `json:"http_method,omitempty"`   // Forwarded HTTP request method
+	HTTPHost        string    `json:"http_host,omitempty"`     // Forwarded HTTP request host
+	HTTPStatus      int       `json:"http_status,omitempty"`   // Upstream HTTP response status
+	RaceWinner      string    `json:"race_winner,omitempty"`   // Load balancer that won a happy-eyeballs race
+	RaceLoser       string    `json:"race_loser,omitempty"`    // Load balancer whose dial lost the race
+	RaceDelayMs     int       `json:"race_delay_ms,omitempty"` // Delay before the second dial started
+	RaceTimeMs      float64   `json:"race_time_ms,omitempty"`  // Time from the first dial until the winner connected
 	ProcessInfo     string    `json:"process_info,omitempty"` // Optional process information
 }
 
@@ -96,10 +109,34 @@ type enhanced_load_balancer struct {
 	current_connections int
 	// Enhanced features for source IP specific weighting
 	source_ip_rules     map[string]source_ip_rule  // source_ip -> custom rule
+	source_ip_ranges    []source_range             // parsed patterns of source_ip_rules, see index_source_ip_rules
 	source_ip_counters  map[string]int             // source_ip -> current connections for this LB
 	total_connections   int                        // total connections handled by this LB
 	success_count       int                        // successful connections
 	failure_count       int                        // failed connections
+	failures_refused    int                        // failures: connection refused
+	failures_unreachable int                       // failures: host or network unreachable
+	failures_timeout    int                        // failures: timed out
+	failures_other      int                        // failures: any other error
+	consecutive_failures int                       // link failures since the last successful dial
+	last_failure        time.Time                  // time of the last failed dial or tier probe
+	tier                int                        // failover tier, tier 1 is used first
+	active_count        int64                      // tracked connections currently open (atomic)
+	connect_latency     float64                    // moving average of connect times in ms
+	latency_samples     int                        // connects measured for connect_latency
+	capacity_down_mbps  int                        // configured downstream capacity, 0 = unknown
+	capacity_up_mbps    int                        // configured upstream capacity, 0 = unknown
+	spill_utilization_pct int                      // spill new connections over at this utilization, 0 = off
+	spill_max_connections int                      // spill new connections over at this many open ones, 0 = off
+	spilling            bool                       // over a spillover threshold, new connections go to the next link
+	spill_since         time.Time                  // when spilling last started or stopped
+	cost_per_gb         float64                    // traffic cost per GB, 0 = unmetered
+	cost_tier           int                        // cost tier, cheaper tiers are preferred
+	accumulated_cost    float64                    // cost of the traffic through this LB
+	tuned_ratio         int                        // contention ratio set by auto-tune, 0 = not tuned
+	tuned_weight        float64                    // smoothed auto-tune ratio before rounding
+	tune_success_count  int                        // success_count at the last auto-tune round
+	tune_failure_count  int                        // failure_count at the last auto-tune round
 	enabled             bool                       // whether this LB is enabled
 	
 	// Real-time traffic monitoring
@@ -211,9 +248,14 @@ func load_source_ip_rules() {
 				lb_list[i].source_ip_rules = make(map[string]source_ip_rule)
 			}
 			for source_ip, rule := range rules {
+				if !valid_source_pattern(source_ip) {
+					log.Printf("[WARN] Ignoring rule for %s -> %s: not an IP, CIDR block or IP range", source_ip, lb_addr)
+					continue
+				}
 				lb_list[i].source_ip_rules[source_ip] = rule
 				log.Printf("[INFO] Applied rule for %s -> %s: ratio=%d", source_ip, lb_addr, rule.ContentionRatio)
 			}
+			index_source_ip_rules(&lb_list[i])
 		}
 	}
 }
@@ -257,30 +299,60 @@ func get_source_ip(conn net.Conn) string {
 }
 
 /*
-Get effective contention ratio for a source IP and load balancer
+Source IP rule of a load balancer that applies to a source IP: its exact IP
+rule, otherwise the most specific CIDR block or range containing it
+*/
+func get_source_ip_rule(lb *enhanced_load_balancer, source_ip string) (source_ip_rule, bool) {
+	return match_source_ip_rule(lb.source_ip_rules, lb.source_ip_ranges, source_ip)
+}
+
+/*
+Get effective contention ratio for a source IP and load balancer: the ratio of
+its source IP rule, otherwise the auto-tuned or configured ratio
 */
 func get_effective_contention_ratio(lb *enhanced_load_balancer, source_ip string) int {
-	if rule, exists := lb.source_ip_rules[source_ip]; exists {
+	if rule, exists := get_source_ip_rule(lb, source_ip); exists {
 		return rule.ContentionRatio
 	}
-	return lb.contention_ratio
+	return get_base_contention_ratio(lb)
+}
+
+/*
+Error when no load balancer may take a connection, with the SOCKS5 reply code
+for the client: CONNECTION_NOT_ALLOWED when the client policy excluded the
+available links, NETWORK_UNREACHABLE otherwise
+*/
+type no_load_balancer_error struct {
+	reply  byte
+	reason string
+}
+
+func (e *no_load_balancer_error) Error() string {
+	return e.reason
 }
 
 /*
-Get a load balancer according to contention ratio with enhanced source IP awareness
+Get a load balancer for a source IP using the strategy configured for it.
+An int seed and a *big.Int bitset of load balancers that already failed
+exclude those from the choice when retrying. A string is the destination
+(host:port) used for destination rules and sticky sessions. Returns a
+*no_load_balancer_error when no load balancer is left.
 */
-func get_enhanced_load_balancer(source_ip string, params ...interface{}) (*enhanced_load_balancer, int) {
+func get_enhanced_load_balancer(source_ip string, params ...interface{}) (*enhanced_load_balancer, int, error) {
 	var _bitset *big.Int
-	if len(params) > 0 {
-		seed := -1
-		for _, p := range params {
-			switch v := p.(type) {
-			case int:
-				seed = v
-			case *big.Int:
-				_bitset = v
-			}
-		}
+	seed := -1
+	destination := ""
+	for _, p := range params {
+		switch v := p.(type) {
+		case int:
+			seed = v
+		case *big.Int:
+			_bitset = v
+		case string:
+			destination = v
+		}
+	}
+	if seed >= 0 || _bitset != nil {
 		if seed < 0 || seed >= len(lb_list) || _bitset == nil {
 			seed = -1
 			_bitset = nil
@@ -296,80 +368,199 @@ func get_enhanced_load_balancer(source_ip string, params ...interface{}) (*enhan
 		source_lb_indices = make(map[string]int)
 	}
 
-	// Get source-specific index or use global index
-	current_index, exists := source_lb_indices[source_ip]
-	if !exists {
-		current_index = lb_index
-		source_lb_indices[source_ip] = current_index
+	// Enabled load balancers that did not already fail for this connection
+	candidates := make([]int, 0, len(lb_list))
+	for i := range lb_list {
+		if lb_list[i].enabled && (_bitset == nil || _bitset.Bit(i) == 0) {
+			candidates = append(candidates, i)
+		}
+	}
+	// Client policies restrict which load balancers a source IP may use
+	available := len(candidates)
+	candidates, _ = select_client_candidates(source_ip, candidates)
+	if len(candidates) == 0 && available > 0 {
+		log.Printf("[WARN] No load balancer allowed for source %s", source_ip)
+		return nil, -1, &no_load_balancer_error{CONNECTION_NOT_ALLOWED, "no load balancer allowed for source " + source_ip}
 	}
 
-	// Handle bitset for failed load balancers
-	if _bitset != nil {
-		for {
-			if _bitset.Bit(current_index) != 0 {
-				lb := &lb_list[current_index]
-				if lb.source_ip_counters == nil {
-					lb.source_ip_counters = make(map[string]int)
-				}
-				lb.source_ip_counters[source_ip] = 0
-				current_index = (current_index + 1) % len(lb_list)
-				source_lb_indices[source_ip] = current_index
-			} else {
-				break
-			}
-		}
+	// Destination rules pin, prefer or avoid load balancers for some destinations
+	candidates, _ = select_destination_candidates(destination, candidates)
+
+	if len(candidates) == 0 {
+		log.Printf("[WARN] No enabled load balancer left for source %s", source_ip)
+		return nil, -1, &no_load_balancer_error{NETWORK_UNREACHABLE, "no load balancer available for source " + source_ip}
 	}
 
-	// Find next available enabled load balancer
-	start_index := current_index
-	for {
-		lb := &lb_list[current_index]
-		if lb.enabled {
-			break
-		}
-		current_index = (current_index + 1) % len(lb_list)
-		if current_index == start_index {
-			// All load balancers are disabled
-			log.Printf("[WARN] All load balancers are disabled for source %s", source_ip)
-			return &lb_list[0], 0 // Return first LB as fallback
+	// Only the best tier with a healthy load balancer takes new connections
+	candidates = select_tier_candidates(candidates)
+
+	// Expensive links are only used while the cheaper ones are full
+	candidates = select_cost_candidates(candidates)
+
+	// Sticky sessions pin a destination to the load balancer it used before
+	sticky := destination != "" && currentSettings.AffinityTTL > 0
+	if sticky {
+		destination = get_affinity_destination(destination)
+		if ilb := lookup_affinity(source_ip, destination, candidates); ilb >= 0 {
+			lb := &lb_list[ilb]
+			lb.total_connections++
+			log.Printf("[DEBUG] Selected LB %d (%s) for source %s, sticky for %s", ilb, lb.address, source_ip, destination)
+			return lb, ilb, nil
 		}
 	}
 
-	lb := &lb_list[current_index]
-	
-	// Initialize counters if needed
-	if lb.source_ip_counters == nil {
-		lb.source_ip_counters = make(map[string]int)
-	}
+	// Links with spillover thresholds are filled in order before the next one is used
+	candidates = select_spillover_candidates(candidates)
 
-	// Get effective contention ratio for this source IP
-	effective_ratio := get_effective_contention_ratio(lb, source_ip)
-	
-	// Increment counters
-	lb.source_ip_counters[source_ip]++
-	lb.current_connections++
+	strategy := get_source_ip_strategy(source_ip)
+	ilb := lb_strategies[strategy].pick(source_ip, candidates)
+
+	lb := &lb_list[ilb]
 	lb.total_connections++
+	if sticky {
+		store_affinity(source_ip, destination, lb)
+	}
 
-	ilb := current_index
+	log.Printf("[DEBUG] Selected LB %d (%s) for source %s, strategy: %s, effective ratio: %d",
+		ilb, lb.address, source_ip, strategy, get_effective_contention_ratio(lb, source_ip))
+	return lb, ilb, nil
+}
 
-	// Check if we need to move to next load balancer
-	if lb.source_ip_counters[source_ip] >= effective_ratio {
-		lb.source_ip_counters[source_ip] = 0
-		current_index = (current_index + 1) % len(lb_list)
-		source_lb_indices[source_ip] = current_index
+// Legacy get_load_balancer function removed - use get_enhanced_load_balancer instead
+
+/*
+Map a dial error to the matching SOCKS5 reply code
+*/
+func dial_error_reply(err error) byte {
+	var dns_error *net.DNSError
+	var net_error net.Error
+	var no_lb_error *no_load_balancer_error
+
+	switch {
+	case errors.As(err, &no_lb_error):
+		return no_lb_error.reply
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return CONNECTION_REFUSED
+	case errors.Is(err, syscall.ETIMEDOUT), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &net_error) && net_error.Timeout():
+		return TTL_EXPIRED
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dns_error):
+		return HOST_UNREACHABLE
+	}
+	// Network unreachable and anything unclassified
+	return NETWORK_UNREACHABLE
+}
+
+/*
+Count a failed connection on a load balancer by error category.
+Returns the SOCKS5 reply code for the error.
+*/
+func record_load_balancer_failure(lb *enhanced_load_balancer, err error) byte {
+	return count_load_balancer_failure(lb, err, true)
+}
+
+/*
+Count a failure of the remote peer on a load balancer, like a BIND peer that
+never connects or an origin that does not answer in time. The link itself
+worked, so its health is left alone. Returns the SOCKS5 reply code for the error.
+*/
+func record_peer_failure(lb *enhanced_load_balancer, err error) byte {
+	return count_load_balancer_failure(lb, err, false)
+}
+
+/*
+Count a failure by error category; dial and bind errors (link set) also count
+against the health of the load balancer
+*/
+func count_load_balancer_failure(lb *enhanced_load_balancer, err error, link bool) byte {
+	reply := dial_error_reply(err)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	lb.failure_count++
+
+	// A refused connection shows the link works, only link failures count against its health
+	if reply == CONNECTION_REFUSED {
+		lb.consecutive_failures = 0
+	} else if link && is_link_failure(err) {
+		lb.consecutive_failures++
+		lb.last_failure = time.Now()
 	}
 
-	// Update global index as well
-	if lb.current_connections >= lb.contention_ratio {
-		lb.current_connections = 0
-		lb_index = (lb_index + 1) % len(lb_list)
+	switch {
+	case reply == CONNECTION_REFUSED:
+		lb.failures_refused++
+	case reply == TTL_EXPIRED:
+		lb.failures_timeout++
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH), reply == HOST_UNREACHABLE:
+		lb.failures_unreachable++
+	default:
+		lb.failures_other++
 	}
+	return reply
+}
+
+// Weight of the newest sample in the connect latency average
+const latency_ewma_alpha = 0.3
 
-	log.Printf("[DEBUG] Selected LB %d (%s) for source %s, effective ratio: %d", ilb, lb.address, source_ip, effective_ratio)
-	return lb, ilb
+/*
+Add the connect time of a successful dial to the moving average of a load balancer
+and end its streak of failed dials
+*/
+func record_connect_latency(lb *enhanced_load_balancer, elapsed time.Duration) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if lb.latency_samples == 0 {
+		lb.connect_latency = ms
+	} else {
+		lb.connect_latency += latency_ewma_alpha * (ms - lb.connect_latency)
+	}
+	lb.latency_samples++
+	lb.consecutive_failures = 0
 }
 
-// Legacy get_load_balancer function removed - use get_enhanced_load_balancer instead
+/*
+Utilization of a load balancer's configured capacity in percent, downstream and
+upstream, from its current traffic rates. A direction without capacity reports 0.
+*/
+func get_link_utilization(lb *enhanced_load_balancer) (float64, float64) {
+	utilization := func(bytes_per_second int64, capacity_mbps int) float64 {
+		if capacity_mbps <= 0 {
+			return 0
+		}
+		return float64(bytes_per_second*8) / float64(capacity_mbps*1000000) * 100
+	}
+	return utilization(lb.bytes_in_per_second, lb.capacity_down_mbps),
+		utilization(lb.bytes_out_per_second, lb.capacity_up_mbps)
+}
+
+/*
+Set the capacity of a load balancer in Mbit/s and persist it
+*/
+func set_load_balancer_capacity(lb_address string, down_mbps int, up_mbps int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range lb_list {
+		if lb_list[i].address == lb_address {
+			lb_list[i].capacity_down_mbps = down_mbps
+			lb_list[i].capacity_up_mbps = up_mbps
+
+			if err := saveLoadBalancerCapacity(lb_address, down_mbps, up_mbps); err != nil {
+				log.Printf("[WARN] Failed to save capacity of load balancer %s: %v", lb_address, err)
+			}
+			log.Printf("[INFO] Capacity of load balancer %s set to %d/%d Mbit/s (down/up)", lb_address, down_mbps, up_mbps)
+			return true
+		}
+	}
+
+	log.Printf("[WARN] Load balancer %s not found", lb_address)
+	return false
+}
 
 /*
 Generate unique connection ID
@@ -381,7 +572,7 @@ func generate_connection_id() string {
 /*
 Add new active connection to tracking
 */
-func add_active_connection(conn net.Conn, remote_addr string, lb *enhanced_load_balancer, lb_index int) string {
+func add_active_connection(conn net.Conn, remote_addr string, lb *enhanced_load_balancer, lb_index int, username string) string {
 	connection_mutex.Lock()
 	defer connection_mutex.Unlock()
 	
@@ -401,13 +592,12 @@ func add_active_connection(conn net.Conn, remote_addr string, lb *enhanced_load_
 		source_port = addr.Port
 	}
 	
-	// Extract destination info
-	if strings.Contains(remote_addr, ":") {
-		parts := strings.Split(remote_addr, ":")
-		dest_ip = parts[0]
-		if len(parts) > 1 {
-			dest_port, _ = strconv.Atoi(parts[1])
-		}
+	// Extract destination info (host:port, [v6]:port or a bare host)
+	if host, port, err := net.SplitHostPort(remote_addr); err == nil {
+		dest_ip = host
+		dest_port, _ = strconv.Atoi(port)
+	} else {
+		dest_ip = remote_addr
 	}
 	
 	active_conn := &active_connection{
@@ -424,10 +614,16 @@ func add_active_connection(conn net.Conn, remote_addr string, lb *enhanced_load_
 		BytesOut:        0,
 		Status:          "active",
 		Protocol:        "TCP",
+		Username:        username,
 		ProcessInfo:     "", // Could be enhanced with process detection
 	}
 	
 	active_connections[conn_id] = active_conn
+	mutex.Lock()
+	if i := load_balancer_index(lb.address); i >= 0 {
+		atomic.AddInt64(&lb_list[i].active_count, 1)
+	}
+	mutex.Unlock()
 	log.Printf("[DEBUG] Added active connection %s: %s:%d -> %s:%d via LB%d", 
 		conn_id, source_ip, source_port, dest_ip, dest_port, lb_index+1)
 	
@@ -452,20 +648,33 @@ func update_connection_traffic(conn_id string, bytes_in, bytes_out int64) {
 		atomic.AddInt64(&total_data_transferred, bytes_in+bytes_out)
 		
 		// Update load balancer traffic stats
-		if lb_index := conn.LBIndex; lb_index >= 0 && lb_index < len(lb_list) {
-			mutex.Lock()
+		mutex.Lock()
+		if lb_index := load_balancer_index(conn.LoadBalancer); lb_index >= 0 {
 			lb_list[lb_index].bytes_transferred += bytes_in + bytes_out
 			lb_list[lb_index].bytes_in_total += bytes_in
 			lb_list[lb_index].bytes_out_total += bytes_out
 			lb_list[lb_index].last_traffic_update = time.Now()
-	mutex.Unlock()
+			account_traffic_cost(&lb_list[lb_index], conn.SourceIP, bytes_in+bytes_out)
 		}
+		mutex.Unlock()
 		
 		// Update client traffic stats
 		updateClientTrafficStats(conn.SourceIP, bytes_in, bytes_out)
 	}
 }
 
+/*
+Apply changes to a tracked connection while holding the connection lock
+*/
+func update_active_connection(conn_id string, update func(conn *active_connection)) {
+	connection_mutex.Lock()
+	defer connection_mutex.Unlock()
+	
+	if conn, exists := active_connections[conn_id]; exists {
+		update(conn)
+	}
+}
+
 /*
 Remove active connection from tracking
 */
@@ -484,11 +693,40 @@ func remove_active_connection(conn_id string) {
 		connection_history = append(connection_history, *conn)
 		
 		delete(active_connections, conn_id)
+		release_active_count(conn)
 		log.Printf("[DEBUG] Removed active connection %s after %v", 
 			conn_id, time.Since(conn.StartTime))
 	}
 }
 
+/*
+Drop a tracked connection from the active count of its load balancer
+*/
+func release_active_count(conn *active_connection) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if i := load_balancer_index(conn.LoadBalancer); i >= 0 {
+		if atomic.AddInt64(&lb_list[i].active_count, -1) < 0 {
+			atomic.StoreInt64(&lb_list[i].active_count, 0)
+		}
+	}
+}
+
+/*
+Index of the load balancer with an address, or -1. Connections are tracked by
+address since removing a load balancer moves the ones after it.
+Called with mutex held.
+*/
+func load_balancer_index(address string) int {
+	for i := range lb_list {
+		if lb_list[i].address == address {
+			return i
+		}
+	}
+	return -1
+}
+
 /*
 Cleanup old connections (performance optimization)
 */
@@ -509,6 +747,7 @@ func cleanup_old_connections() {
 			connection_history = append(connection_history, *conn)
 			
 			delete(active_connections, id)
+			release_active_count(conn)
 			removed++
 		}
 	}
@@ -658,16 +897,21 @@ Handle connections in tunnel mode with enhanced load balancing
 */
 func handle_tunnel_connection(conn net.Conn) {
 	source_ip := get_source_ip(conn)
-	load_balancer, i := get_enhanced_load_balancer(source_ip)
+	load_balancer, i, err := get_enhanced_load_balancer(source_ip)
+	if err != nil {
+		conn.Close()
+		return
+	}
 	var _bitset *big.Int
 	complete := 1 == len(lb_list)
 
 retry:
-	remote_addr, _ := net.ResolveTCPAddr("tcp4", load_balancer.address)
-	remote_conn, err := net.DialTCP("tcp4", nil, remote_addr)
+	remote_addr, _ := net.ResolveTCPAddr("tcp", load_balancer.address)
+	dial_start := time.Now()
+	remote_conn, err := net.DialTCP("tcp", nil, remote_addr)
 
 	if err != nil {
-		load_balancer.failure_count++
+		record_load_balancer_failure(load_balancer, err)
 		log.Printf("[WARN] %s -> %s {%s} LB: %d, Source: %s", load_balancer.address, remote_addr.String(), err, i, source_ip)
 
 		if !complete && _bitset == nil {
@@ -684,8 +928,9 @@ retry:
 		}
 
 		if !complete {
-			load_balancer, i = get_enhanced_load_balancer(source_ip, i, _bitset)
-			goto retry
+			if load_balancer, i, err = get_enhanced_load_balancer(source_ip, i, _bitset); err == nil {
+				goto retry
+			}
 		}
 
 		log.Printf("[WARN] All load balancers failed for source %s", source_ip)
@@ -693,18 +938,64 @@ retry:
 		return
 	}
 
+	record_connect_latency(load_balancer, time.Since(dial_start))
 	load_balancer.success_count++
 	log.Printf("[DEBUG] Tunnelled %s to %s LB: %d", source_ip, load_balancer.address, i)
 	
 	// Add connection tracking for tunnel mode
-	conn_id := add_active_connection(conn, load_balancer.address, load_balancer, i)
+	conn_id := add_active_connection(conn, load_balancer.address, load_balancer, i, "")
 	pipe_connections(conn, remote_conn, conn_id)
 }
 
+// HTTP methods recognised when auto-detecting the protocol of a connection
+var http_methods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
 /*
-Calls the appropriate handle_connections based on tunnel mode with enhanced features
+Peek at the first bytes of a connection to find out which protocol the client
+speaks: SOCKS (version 0x05 or 0x04) or HTTP (ASCII method followed by a space).
+Returns the listener mode to handle it with and the connection wrapping the
+peeked bytes.
 */
-func handle_connection(conn net.Conn, tunnel bool) {
+func detect_protocol(conn net.Conn) (string, net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	wrapped := &buffered_conn{Conn: conn, reader: reader}
+
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", nil, fmt.Errorf("no data received: %v", err)
+	}
+
+	switch {
+	case first[0] == 5 || first[0] == 4:
+		return "socks", wrapped, nil
+	case first[0] >= 'A' && first[0] <= 'Z':
+		for n := 2; n <= 8; n++ {
+			peeked, err := reader.Peek(n)
+			if err != nil {
+				break
+			}
+			if peeked[n-1] == ' ' {
+				method := string(peeked[:n-1])
+				for _, m := range http_methods {
+					if m == method {
+						return "http", wrapped, nil
+					}
+				}
+				return "", nil, fmt.Errorf("unknown HTTP method %q", method)
+			}
+			if peeked[n-1] < 'A' || peeked[n-1] > 'Z' {
+				break
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("unknown protocol (first byte 0x%02x)", first[0])
+}
+
+/*
+Calls the appropriate handler for the listener mode ("socks", "tunnel", "http"
+or "auto") with enhanced features
+*/
+func handle_connection(conn net.Conn, mode string) {
 	// Check goroutine limit
 	if atomic.LoadInt64(&active_goroutines) >= max_goroutines {
 		log.Printf("[WARN] Maximum goroutines reached, rejecting connection")
@@ -731,19 +1022,51 @@ func handle_connection(conn net.Conn, tunnel bool) {
 	conn.SetDeadline(time.Now().Add(handshake_timeout))
 	
 	source_ip := get_source_ip(conn)
+
+	if mode == "auto" {
+		detected, wrapped, err := detect_protocol(conn)
+		if err != nil {
+			log.Printf("[WARN] Closing connection from %s: %v", source_ip, err)
+			conn.Close()
+			return
+		}
+		if debug_mode {
+			log.Printf("[DEBUG] Detected %s client from %s", detected, source_ip)
+		}
+		mode, conn = detected, wrapped
+	}
 	
-	if tunnel {
+	if mode == "tunnel" {
 		handle_tunnel_connection(conn)
+	} else if mode == "http" {
+		// Serve the client in a separate goroutine, like SOCKS server responses
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[ERROR] Panic in HTTP proxy for %s: %v", source_ip, r)
+					conn.Close()
+				}
+			}()
+			handle_http_connection(conn)
+		}()
 	} else {
 		// Handle SOCKS connection with immediate processing
 		if debug_mode {
 			log.Printf("[DEBUG] Starting SOCKS handshake for %s", source_ip)
 		}
 		
-		if address, err := handle_socks_connection(conn); err == nil {
+		if request, err := handle_socks_connection(conn); err == nil {
+			address := request.address
 			if debug_mode {
 				log.Printf("[DEBUG] SOCKS handshake successful for %s -> %s", source_ip, address)
 			}
+
+			// UDP destinations are checked per datagram
+			if request.command != UDP_ASSOCIATE && !check_proxy_acl(source_ip, address, request.protocol) {
+				request.reply(conn, CONNECTION_NOT_ALLOWED, nil)
+				conn.Close()
+				return
+			}
 			
 			// Start server response in separate goroutine to prevent blocking
 			go func() {
@@ -754,10 +1077,23 @@ func handle_connection(conn net.Conn, tunnel bool) {
 					}
 				}()
 				
-				if debug_mode {
-					log.Printf("[DEBUG] Starting enhanced_server_response for %s -> %s", source_ip, address)
+				switch request.command {
+				case UDP_ASSOCIATE:
+					if debug_mode {
+						log.Printf("[DEBUG] Starting UDP association for %s (client %s)", source_ip, address)
+					}
+					handle_udp_associate(conn, request, source_ip)
+				case BIND:
+					if debug_mode {
+						log.Printf("[DEBUG] Starting BIND for %s (expected peer %s)", source_ip, address)
+					}
+					handle_socks_bind(conn, request, source_ip)
+				default:
+					if debug_mode {
+						log.Printf("[DEBUG] Starting enhanced_server_response for %s -> %s", source_ip, address)
+					}
+					enhanced_server_response(conn, request, source_ip)
 				}
-				enhanced_server_response(conn, address, source_ip)
 			}()
 		} else {
 			if debug_mode {
@@ -783,6 +1119,8 @@ func detect_interfaces() {
 				if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 					if ipnet.IP.To4() != nil {
 						fmt.Printf("[+] %s, IPv4:%s\n", iface.Name, ipnet.IP.String())
+					} else if !ipnet.IP.IsLinkLocalUnicast() {
+						fmt.Printf("[+] %s, IPv6:%s\n", iface.Name, ipnet.IP.String())
 					}
 				}
 			}
@@ -802,10 +1140,8 @@ func get_iface_from_ip(ip string) string {
 			addrs, _ := iface.Addrs()
 			for _, addr := range addrs {
 				if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-					if ipnet.IP.To4() != nil {
-						if ipnet.IP.String() == ip {
-							return iface.Name
-						}
+					if ipnet.IP.Equal(net.ParseIP(ip)) {
+						return iface.Name
 					}
 				}
 			}
@@ -833,14 +1169,14 @@ func parse_load_balancers(args []string, tunnel bool) {
 		var err error
 
 		if tunnel {
-			ip_or_fqdn_port := strings.Split(splitted[0], ":")
-			if len(ip_or_fqdn_port) != 2 {
+			host, port, split_err := net.SplitHostPort(splitted[0])
+			if split_err != nil {
 				log.Fatal("[FATAL] Invalid address specification ", splitted[0])
 				return
 			}
 
-			lb_ip_or_fqdn = ip_or_fqdn_port[0]
-			lb_port, err = strconv.Atoi(ip_or_fqdn_port[1])
+			lb_ip_or_fqdn = host
+			lb_port, err = strconv.Atoi(port)
 			if err != nil || lb_port <= 0 || lb_port > 65535 {
 				log.Fatal("[FATAL] Invalid port ", splitted[0])
 				return
@@ -852,7 +1188,7 @@ func parse_load_balancers(args []string, tunnel bool) {
 		}
 
 		// FQDN not supported for tunnel modes
-		if !tunnel && net.ParseIP(lb_ip_or_fqdn).To4() == nil {
+		if !tunnel && net.ParseIP(lb_ip_or_fqdn) == nil {
 			log.Fatal("[FATAL] Invalid address ", lb_ip_or_fqdn)
 		}
 
@@ -872,22 +1208,17 @@ func parse_load_balancers(args []string, tunnel bool) {
 			}
 		}
 
-		slbport := ""
-		if tunnel {
-			slbport = ":" + strconv.Itoa(lb_port)
-		}
-
-		log.Printf("[INFO] Load balancer %d: %s%s, contention ratio: %d\n", idx+1, lb_ip_or_fqdn, slbport, cont_ratio)
-		
 		// Store address differently for tunnel vs non-tunnel mode
 		var address string
 		if tunnel {
-			address = fmt.Sprintf("%s:%d", lb_ip_or_fqdn, lb_port)
+			address = net.JoinHostPort(lb_ip_or_fqdn, strconv.Itoa(lb_port))
 		} else {
 			address = lb_ip_or_fqdn // Only IP for non-tunnel mode
 		}
+
+		log.Printf("[INFO] Load balancer %d: %s, contention ratio: %d\n", idx+1, address, cont_ratio)
 		
-		lb_list[idx] = enhanced_load_balancer{address: address, iface: iface, contention_ratio: cont_ratio, current_connections: 0, source_ip_rules: make(map[string]source_ip_rule), source_ip_counters: make(map[string]int), total_connections: 0, success_count: 0, failure_count: 0, enabled: true}
+		lb_list[idx] = enhanced_load_balancer{address: address, iface: iface, contention_ratio: cont_ratio, current_connections: 0, source_ip_rules: make(map[string]source_ip_rule), source_ip_counters: make(map[string]int), total_connections: 0, success_count: 0, failure_count: 0, enabled: true, tier: 1, cost_tier: 1}
 	}
 }
 
@@ -946,6 +1277,11 @@ func main() {
 	if err := loadLoadBalancersFromDatabase(); err != nil {
 		log.Printf("[WARN] Failed to load load balancers from database: %v", err)
 	}
+	load_cost_totals()
+	load_race_rules()
+	load_destination_rules()
+	load_client_policies()
+	load_proxy_acls()
 
 	// Disable timestamp in log messages if quiet mode
 	if quiet_mode {
@@ -976,15 +1312,16 @@ func main() {
 		startWebServer(currentSettings.WebPort)
 	}
 
-	local_bind_address := fmt.Sprintf("%s:%d", currentSettings.ListenHost, currentSettings.ListenPort)
+	// The primary listener is a row of the listeners table, seeded from the listen settings
+	init_primary_listener()
 
-	// Start local server
-	l, err := net.Listen("tcp4", local_bind_address)
-	if err != nil {
-		log.Fatalf("[FATAL] Could not start local server on %s: %v", local_bind_address, err)
+	// Optional HTTP proxy listener sharing the same dispatcher
+	if currentSettings.HTTPProxyPort > 0 {
+		go start_http_proxy(net.JoinHostPort(currentSettings.ListenHost, strconv.Itoa(currentSettings.HTTPProxyPort)))
 	}
-	
-	log.Printf("[INFO] Enhanced SOCKS5 proxy started on %s", local_bind_address)
+
+	// Listeners from the listeners table, the primary one included (dual-stack when listening on "::")
+	start_configured_listeners()
 	log.Printf("[INFO] Web GUI available at http://localhost:%d", currentSettings.WebPort)
 	log.Printf("[INFO] Load balancing %d interfaces", len(lb_list))
 	
@@ -1004,7 +1341,6 @@ func main() {
 		detect_interfaces()
 	}
 	
-	defer l.Close()
 	defer stopWebServer()
 	defer cleanup_gateway_mode()
 	
@@ -1023,10 +1359,24 @@ func main() {
 				syncLoadBalancersToDatabase()
 				// Cleanup old connections
 				cleanup_old_connections()
+				purge_expired_affinities()
+				purge_idle_strategy_state()
 			}
 		}
 	}()
 	
+	// Persist accumulated traffic costs
+	go func() {
+		ticker := time.NewTicker(cost_save_interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			save_cost_totals()
+		}
+	}()
+
+	// Recompute contention ratios from measurements when auto-tune is on
+	go auto_tune_loop()
+
 	// Start resource monitoring
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -1046,20 +1396,14 @@ func main() {
 		}
 	}()
 	
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			log.Println("[WARN] Could not accept connection")
-		} else {
-			go handle_connection(conn, currentSettings.TunnelMode)
-		}
-	}
+	// Listeners serve connections in their own goroutines
+	select {}
 }
 
 /*
 Add or update a source IP rule for a specific load balancer
 */
-func add_source_ip_rule(lb_address string, source_ip string, contention_ratio int, description string) bool {
+func add_source_ip_rule(lb_address string, source_ip string, contention_ratio int, description string, strategy string) bool {
 	mutex.Lock()
 	defer mutex.Unlock()
 	
@@ -1073,10 +1417,12 @@ func add_source_ip_rule(lb_address string, source_ip string, contention_ratio in
 				SourceIP:        source_ip,
 				ContentionRatio: contention_ratio,
 				Description:     description,
+				Strategy:        strategy,
 			}
+			index_source_ip_rules(&lb_list[i])
 			
-			log.Printf("[INFO] Added source IP rule: %s -> %s (ratio: %d) - %s", 
-				source_ip, lb_address, contention_ratio, description)
+			log.Printf("[INFO] Added source IP rule: %s -> %s (ratio: %d, strategy: %s) - %s", 
+				source_ip, lb_address, contention_ratio, strategy, description)
 			
 			// Save to file
 			save_source_ip_rules()
@@ -1100,6 +1446,7 @@ func remove_source_ip_rule(lb_address string, source_ip string) bool {
 			if lb_list[i].source_ip_rules != nil {
 				if _, exists := lb_list[i].source_ip_rules[source_ip]; exists {
 					delete(lb_list[i].source_ip_rules, source_ip)
+					index_source_ip_rules(&lb_list[i])
 					log.Printf("[INFO] Removed source IP rule: %s -> %s", source_ip, lb_address)
 					
 					// Save to file
@@ -1391,20 +1738,11 @@ func handle_transparent_connection(conn net.Conn) {
 	source_ip := get_source_ip(conn)
 	log.Printf("[DEBUG] Transparent proxy: %s -> %s", source_ip, originalDest)
 	
-	// Use enhanced load balancer for transparent connections
-	load_balancer, i := get_enhanced_load_balancer(source_ip)
-	
-	// Create connection to target through selected load balancer
-	local_tcpaddr, _ := net.ResolveTCPAddr("tcp4", load_balancer.address)
-	dialer := net.Dialer{
-		LocalAddr: local_tcpaddr,
-		Timeout:   10 * time.Second,
-	}
-	
-	remote_conn, err := dialer.Dial("tcp", originalDest)
+	// Connect to the target through the selected load balancer, failing over to the next ones
+	outcome, err := dial_with_retry(source_ip, originalDest)
+	remote_conn, load_balancer, i := outcome.conn, outcome.load_balancer, outcome.index
 	if err != nil {
-		load_balancer.failure_count++
-		log.Printf("[WARN] Transparent proxy failed to connect to %s via %s: %v", originalDest, load_balancer.address, err)
+		log.Printf("[WARN] Transparent proxy failed to connect to %s: %v", originalDest, err)
 		return
 	}
 	defer remote_conn.Close()
@@ -1413,7 +1751,8 @@ func handle_transparent_connection(conn net.Conn) {
 	log.Printf("[DEBUG] Transparent proxy connected: %s -> %s via LB%d (%s)", source_ip, originalDest, i, load_balancer.address)
 	
 	// Add connection tracking
-	conn_id := add_active_connection(conn, originalDest, load_balancer, i)
+	conn_id := add_active_connection(conn, originalDest, load_balancer, i, "")
+	record_race(conn_id, outcome.race)
 	pipe_connections(conn, remote_conn, conn_id)
 }
 
@@ -1759,6 +2098,8 @@ func updateLoadBalancerTrafficStats() {
 			}
 		}
 		lb.traffic_mutex.Unlock()
+		
+		update_spillover_state(lb)
 	}
 }
 
@@ -0,0 +1,72 @@
+// main_test.go
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Set up by main(), the background statistics goroutines lock it
+	mutex = &sync.Mutex{}
+	os.Exit(m.Run())
+}
+
+func TestDetectProtocol(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		mode  string
+	}{
+		{"SOCKS5 greeting", "\x05\x01\x00", "socks"},
+		{"SOCKS4 CONNECT", "\x04\x01\x00\x50\x7f\x00\x00\x01user\x00", "socks"},
+		{"SOCKS4a CONNECT", "\x04\x01\x00\x50\x00\x00\x00\x01\x00example.com\x00", "socks"},
+		{"HTTP GET", "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "http"},
+		{"HTTP CONNECT", "CONNECT example.com:443 HTTP/1.1\r\n\r\n", "http"},
+		{"HTTP OPTIONS", "OPTIONS * HTTP/1.1\r\n\r\n", "http"},
+		{"unknown method", "BREW /pot HTTP/1.1\r\n\r\n", ""},
+		{"lowercase method", "get / HTTP/1.1\r\n\r\n", ""},
+		{"method without space", "GET", ""},
+		{"method too long", "PROPPATCHX / HTTP/1.1\r\n\r\n", ""},
+		{"TLS handshake", "\x16\x03\x01\x02\x00", ""},
+		{"SOCKS version 3", "\x03\x01\x00", ""},
+		{"no data", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write([]byte(tt.input))
+				client.Close()
+			}()
+
+			mode, conn, err := detect_protocol(server)
+			if tt.mode == "" {
+				if err == nil {
+					t.Fatalf("detect_protocol(%q) = %q, want an error", tt.input, mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detect_protocol(%q) error: %v", tt.input, err)
+			}
+			if mode != tt.mode {
+				t.Errorf("detect_protocol(%q) = %q, want %q", tt.input, mode, tt.mode)
+			}
+
+			// The handler must see the peeked bytes
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("reading detected connection: %v", err)
+			}
+			if string(data) != tt.input {
+				t.Errorf("detected connection read %q, want %q", data, tt.input)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// racing.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+Outcome of a happy-eyeballs race between two load balancers
+*/
+type race_result struct {
+	Winner      string        // address of the load balancer whose connection is used
+	Loser       string        // address of the load balancer whose dial was dropped or failed
+	Delay       time.Duration // delay before the second dial started
+	ConnectTime time.Duration // from the first dial until the winner connected
+}
+
+// Race rules by ID order, first match wins, protected by race_rules_mutex
+var race_rules []DBRaceRule
+var race_rules_mutex sync.RWMutex
+
+/*
+Load race rules from the database into memory
+*/
+func load_race_rules() {
+	rules, err := loadRaceRules()
+	if err != nil {
+		log.Printf("[WARN] Failed to load race rules from database: %v", err)
+		return
+	}
+
+	race_rules_mutex.Lock()
+	race_rules = rules
+	race_rules_mutex.Unlock()
+}
+
+/*
+Race rules for the web API
+*/
+func get_race_rules() []DBRaceRule {
+	race_rules_mutex.RLock()
+	defer race_rules_mutex.RUnlock()
+
+	rules := make([]DBRaceRule, len(race_rules))
+	copy(rules, race_rules)
+	return rules
+}
+
+/*
+Delay after which a second load balancer is raced against the first for a
+destination, and whether to race at all. The first matching race rule decides,
+otherwise the global happy_eyeballs setting; a rule without a delay uses the
+global delay.
+*/
+func get_race_delay(destination string) (time.Duration, bool) {
+	delay_ms := currentSettings.HappyEyeballsDelayMs
+	race := currentSettings.HappyEyeballs
+
+	race_rules_mutex.RLock()
+	for _, rule := range race_rules {
+		if match_destination(rule.Destination, rule.Port, destination) {
+			race = rule.Race
+			if rule.DelayMs > 0 {
+				delay_ms = rule.DelayMs
+			}
+			break
+		}
+	}
+	race_rules_mutex.RUnlock()
+
+	if delay_ms <= 0 {
+		delay_ms = defaultSettings.HappyEyeballsDelayMs
+	}
+	return time.Duration(delay_ms) * time.Millisecond, race
+}
+
+/*
+Record the outcome of a happy-eyeballs race on an active connection
+*/
+func record_race(conn_id string, race *race_result) {
+	if race == nil {
+		return
+	}
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.RaceWinner = race.Winner
+		ac.RaceLoser = race.Loser
+		ac.RaceDelayMs = int(race.Delay.Milliseconds())
+		ac.RaceTimeMs = float64(race.ConnectTime.Microseconds()) / 1000
+	})
+}
+
+/*
+Close connections of dials that lost a race once they complete
+*/
+func close_lost_dials(results chan dial_attempt, pending int) {
+	for ; pending > 0; pending-- {
+		if result := <-results; result.conn != nil {
+			result.conn.Close()
+		}
+	}
+}
+
+/*
+Describe a race rule for logs
+*/
+func describe_race_rule(rule DBRaceRule) string {
+	destination := rule.Destination
+	if rule.Port != 0 {
+		destination = fmt.Sprintf("%s port %d", destination, rule.Port)
+	}
+	if !rule.Race {
+		return destination + ": no racing"
+	}
+	if rule.DelayMs > 0 {
+		return fmt.Sprintf("%s: race after %d ms", destination, rule.DelayMs)
+	}
+	return destination + ": race after the global delay"
+}
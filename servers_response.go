@@ -5,6 +5,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
 	"time"
@@ -13,64 +15,69 @@ import (
 // Legacy server_response function removed - use enhanced_server_response instead
 
 /*
-	Enhanced servers response of SOCKS5 for non Linux systems with source IP awareness
+	Enhanced servers response of SOCKS4/5 for non Linux systems with source IP awareness
 */
-func enhanced_server_response(local_conn net.Conn, remote_address string, source_ip string) {
-	load_balancer, i := get_enhanced_load_balancer(source_ip)
-
-	// Parse local IP (without port for non-tunnel mode)
-	local_ip := net.ParseIP(load_balancer.address)
-	if local_ip == nil {
-		load_balancer.failure_count++
-		log.Printf("[WARN] Invalid local IP %s", load_balancer.address)
-		local_conn.Write([]byte{5, NETWORK_UNREACHABLE, 0, 1, 0, 0, 0, 0, 0, 0})
-		local_conn.Close()
-		return
-	}
-	
-	// Determine if we're using IPv4 or IPv6 for local interface
-	var network string
-	var local_tcpaddr *net.TCPAddr
-	
-	if local_ip.To4() != nil {
-		// Local IP is IPv4, prefer IPv4 connections
-		network = "tcp4"
-		local_tcpaddr = &net.TCPAddr{IP: local_ip, Port: 0}
-	} else {
-		// Local IP is IPv6, prefer IPv6 connections
-		network = "tcp6"
-		local_tcpaddr = &net.TCPAddr{IP: local_ip, Port: 0}
-	}
-	
-	// Simple validation - skip complex DNS resolution for now
+func enhanced_server_response(local_conn net.Conn, request *proxy_request, source_ip string) {
+	remote_address := request.address
 	if debug_mode {
-		log.Printf("[DEBUG] Processing %s using %s network for source %s", remote_address, network, source_ip)
-	}
-	
-	// Let the dialer handle DNS resolution with timeout - much simpler and more reliable
-	
-	// Create dialer with local address binding and aggressive timeouts
-	dialer := &net.Dialer{
-		LocalAddr: local_tcpaddr,
-		Timeout:   5 * time.Second,  // 5 second total timeout (DNS + connect)
-		KeepAlive: -1,              // Disable keep-alive to avoid hanging connections
+		log.Printf("[DEBUG] Processing %s for source %s", remote_address, source_ip)
 	}
-	
-	// Dial to remote address with timeout
-	remote_conn, err := dialer.Dial(network, remote_address)
+
+	outcome, err := dial_with_retry(source_ip, remote_address)
 	if err != nil {
-		load_balancer.failure_count++
-		log.Printf("[WARN] %s -> %s via %s {%s} LB: %d, Source: %s", remote_address, load_balancer.address, load_balancer.address, err, i, source_ip)
-		local_conn.Write([]byte{5, NETWORK_UNREACHABLE, 0, 1, 0, 0, 0, 0, 0, 0})
+		request.reply(local_conn, outcome.reply, nil)
 		local_conn.Close()
 		return
 	}
 	
+	remote_conn, load_balancer, i := outcome.conn, outcome.load_balancer, outcome.index
 	load_balancer.success_count++
 	log.Printf("[DEBUG] %s -> %s via %s LB: %d, Source: %s", remote_address, load_balancer.address, load_balancer.address, i, source_ip)
-	local_conn.Write([]byte{5, SUCCESS, 0, 1, 0, 0, 0, 0, 0, 0})
+	request.reply(local_conn, SUCCESS, remote_conn.LocalAddr())
 	
 	// Add connection tracking for enhanced function
-	conn_id := add_active_connection(local_conn, remote_address, load_balancer, i)
+	conn_id := add_active_connection(local_conn, remote_address, load_balancer, i, request.username)
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.Protocol = request.protocol
+	})
+	record_race(conn_id, outcome.race)
 	pipe_connections(local_conn, remote_conn, conn_id)
 }
+
+/*
+	Dials a remote TCP address from the IPs of a load balancer for non Linux systems
+*/
+func dial_via_load_balancer(ctx context.Context, load_balancer *enhanced_load_balancer, remote_address string) (net.Conn, error) {
+	return dial_with_family_fallback(ctx, load_balancer, remote_address,
+		func(ctx context.Context, network string, local_ip net.IP, address string) (net.Conn, error) {
+			// Aggressive timeouts per attempt
+			dialer := &net.Dialer{
+				LocalAddr: &net.TCPAddr{IP: local_ip, Port: 0},
+				Timeout:   5 * time.Second,  // 5 second connect timeout
+				KeepAlive: -1,              // Disable keep-alive to avoid hanging connections
+			}
+			return dialer.DialContext(ctx, network, address)
+		})
+}
+
+/*
+	Opens a UDP relay socket bound to the IP of a load balancer for non Linux systems
+*/
+func listen_udp_via_load_balancer(load_balancer *enhanced_load_balancer) (*net.UDPConn, error) {
+	local_ip := net.ParseIP(load_balancer.address)
+	if local_ip == nil {
+		return nil, fmt.Errorf("invalid local IP %s", load_balancer.address)
+	}
+	return net.ListenUDP("udp", &net.UDPAddr{IP: local_ip, Port: 0})
+}
+
+/*
+	Opens a TCP listener bound to the IP of a load balancer for non Linux systems
+*/
+func listen_tcp_via_load_balancer(load_balancer *enhanced_load_balancer) (*net.TCPListener, error) {
+	local_ip := net.ParseIP(load_balancer.address)
+	if local_ip == nil {
+		return nil, fmt.Errorf("invalid local IP %s", load_balancer.address)
+	}
+	return net.ListenTCP("tcp", &net.TCPAddr{IP: local_ip, Port: 0})
+}
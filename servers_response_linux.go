@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"syscall"
@@ -10,39 +11,91 @@ import (
 // Legacy server_response function removed - use enhanced_server_response instead
 
 /*
-	Enhanced servers response of SOCKS5 for linux systems with source IP awareness
+	Enhanced servers response of SOCKS4/5 for linux systems with source IP awareness
 */
-func enhanced_server_response(local_conn net.Conn, remote_address string, source_ip string) {
-	load_balancer, i := get_enhanced_load_balancer(source_ip)
-	local_tcpaddr, _ := net.ResolveTCPAddr("tcp4", load_balancer.address)
+func enhanced_server_response(local_conn net.Conn, request *proxy_request, source_ip string) {
+	remote_address := request.address
+	outcome, err := dial_with_retry(source_ip, remote_address)
+	if err != nil {
+		request.reply(local_conn, outcome.reply, nil)
+		local_conn.Close()
+		return
+	}
 
-	dialer := net.Dialer{
-		LocalAddr: local_tcpaddr,
+	remote_conn, load_balancer, i := outcome.conn, outcome.load_balancer, outcome.index
+	load_balancer.success_count++
+	log.Printf("[DEBUG] %s -> %s via %s LB: %d, Source: %s", remote_address, load_balancer.address, load_balancer.iface, i, source_ip)
+	request.reply(local_conn, SUCCESS, remote_conn.LocalAddr())
+	
+	// Add connection tracking for enhanced function
+	conn_id := add_active_connection(local_conn, remote_address, load_balancer, i, request.username)
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.Protocol = request.protocol
+	})
+	record_race(conn_id, outcome.race)
+	pipe_connections(local_conn, remote_conn, conn_id)
+}
+
+/*
+	Dials a remote TCP address bound to the IPs and interface of a load balancer (linux)
+*/
+func dial_via_load_balancer(ctx context.Context, load_balancer *enhanced_load_balancer, remote_address string) (net.Conn, error) {
+	return dial_with_family_fallback(ctx, load_balancer, remote_address,
+		func(ctx context.Context, network string, local_ip net.IP, address string) (net.Conn, error) {
+			dialer := net.Dialer{
+				LocalAddr: &net.TCPAddr{IP: local_ip, Port: 0},
+				Control: func(network, address string, c syscall.RawConn) error {
+					return c.Control(func(fd uintptr) {
+						// NOTE: Run with root or use setcap to allow interface binding
+						// sudo setcap cap_net_raw=eip ./go-dispatch-proxy
+						if err := syscall.BindToDevice(int(fd), load_balancer.iface); err != nil {
+							log.Printf("[WARN] Couldn't bind to interface %s (%s)", load_balancer.iface, load_balancer.address)
+						}
+					})
+				},
+			}
+			return dialer.DialContext(ctx, network, address)
+		})
+}
+
+/*
+	Opens a UDP relay socket bound to the IP and interface of a load balancer (linux)
+*/
+func listen_udp_via_load_balancer(load_balancer *enhanced_load_balancer) (*net.UDPConn, error) {
+	listen_config := net.ListenConfig{
 		Control: func(network, address string, c syscall.RawConn) error {
 			return c.Control(func(fd uintptr) {
-				// NOTE: Run with root or use setcap to allow interface binding
-				// sudo setcap cap_net_raw=eip ./go-dispatch-proxy
 				if err := syscall.BindToDevice(int(fd), load_balancer.iface); err != nil {
-					log.Printf("[WARN] Couldn't bind to interface %s LB: %d, Source: %s", load_balancer.iface, i, source_ip)
+					log.Printf("[WARN] Couldn't bind UDP socket to interface %s", load_balancer.iface)
 				}
 			})
 		},
 	}
 
-	remote_conn, err := dialer.Dial("tcp4", remote_address)
+	packet_conn, err := listen_config.ListenPacket(context.Background(), "udp", net.JoinHostPort(load_balancer.address, "0"))
 	if err != nil {
-		load_balancer.failure_count++
-		log.Printf("[WARN] %s -> %s via %s {%s} LB: %d, Source: %s", remote_address, load_balancer.address, load_balancer.iface, err, i, source_ip)
-		local_conn.Write([]byte{5, NETWORK_UNREACHABLE, 0, 1, 0, 0, 0, 0, 0, 0})
-		local_conn.Close()
-		return
+		return nil, err
 	}
+	return packet_conn.(*net.UDPConn), nil
+}
 
-	load_balancer.success_count++
-	log.Printf("[DEBUG] %s -> %s via %s LB: %d, Source: %s", remote_address, load_balancer.address, load_balancer.iface, i, source_ip)
-	local_conn.Write([]byte{5, SUCCESS, 0, 1, 0, 0, 0, 0, 0, 0})
-	
-	// Add connection tracking for enhanced function
-	conn_id := add_active_connection(local_conn, remote_address, load_balancer, i)
-	pipe_connections(local_conn, remote_conn, conn_id)
+/*
+	Opens a TCP listener bound to the IP and interface of a load balancer (linux)
+*/
+func listen_tcp_via_load_balancer(load_balancer *enhanced_load_balancer) (*net.TCPListener, error) {
+	listen_config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				if err := syscall.BindToDevice(int(fd), load_balancer.iface); err != nil {
+					log.Printf("[WARN] Couldn't bind listener to interface %s", load_balancer.iface)
+				}
+			})
+		},
+	}
+
+	listener, err := listen_config.Listen(context.Background(), "tcp", net.JoinHostPort(load_balancer.address, "0"))
+	if err != nil {
+		return nil, err
+	}
+	return listener.(*net.TCPListener), nil
 }
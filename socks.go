@@ -5,56 +5,148 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strconv"
 	"time"
 )
 
-/*
+// Parsed proxy request handed over to the dispatcher
+type proxy_request struct {
+	protocol string // "SOCKS5", "SOCKS4" or "HTTP"
+	command  byte   // CONNECT, BIND or UDP_ASSOCIATE
+	address  string // destination host:port (expected peer for BIND, client address for UDP_ASSOCIATE)
+	username string // authenticated username, empty for NOAUTH and SOCKS4
+}
 
- */
-func client_greeting(conn net.Conn) (byte, []byte, error) {
-	buf := make([]byte, 2)
+/*
+	Sends the protocol specific reply for a request. SOCKS5 status codes are
+	translated for older protocols.
+*/
+func (request *proxy_request) reply(conn net.Conn, status byte, bound net.Addr) error {
+	var reply []byte
+	switch request.protocol {
+	case "SOCKS4":
+		reply = build_socks4_reply(status, bound)
+	case "HTTP":
+		reply = build_http_connect_reply(status)
+	default:
+		reply = build_socks_reply(status, bound)
+	}
 
-	if nRead, err := conn.Read(buf); err != nil || nRead != len(buf) {
-		return 0, nil, errors.New("[WARN] client greeting failed")
+	if nWrite, err := conn.Write(reply); err != nil || nWrite != len(reply) {
+		return errors.New("[WARN] reply to client failed")
 	}
+	return nil
+}
 
-	socks_version := buf[0]
-	num_auth_methods := buf[1]
+/*
 
+ */
+func client_greeting(conn net.Conn, num_auth_methods byte) ([]byte, error) {
 	auth_methods := make([]byte, num_auth_methods)
 
 	if nRead, err := conn.Read(auth_methods); err != nil || nRead != int(num_auth_methods) {
-		return 0, nil, errors.New("[WARN] client greeting failed")
+		return nil, errors.New("[WARN] client greeting failed")
 	}
 
-	return socks_version, auth_methods, nil
+	return auth_methods, nil
 }
 
 /*
+	Selects the authentication method from the ones offered by the client.
+	USERNAME_PASSWORD is mandatory when SOCKS authentication is enabled,
+	otherwise NOAUTH is preferred. Returns the authenticated username, if any.
+*/
+func servers_choice(conn net.Conn, auth_methods []byte) (string, error) {
+	offered := func(method byte) bool {
+		for _, m := range auth_methods {
+			if m == method {
+				return true
+			}
+		}
+		return false
+	}
 
- */
-func servers_choice(conn net.Conn) error {
+	var method byte = NO_ACCEPTABLE_METHOD
+	if offered(USERNAME_PASSWORD) {
+		method = USERNAME_PASSWORD
+	}
+	if !currentSettings.SocksAuth && offered(NOAUTH) {
+		method = NOAUTH
+	}
 
-	if nWrite, err := conn.Write([]byte{5, 0}); err != nil || nWrite != 2 {
-		return errors.New("[WARN] servers choice failed")
+	if nWrite, err := conn.Write([]byte{5, method}); err != nil || nWrite != 2 {
+		return "", errors.New("[WARN] servers choice failed")
+	}
+
+	switch method {
+	case NOAUTH:
+		return "", nil
+	case USERNAME_PASSWORD:
+		return client_authentication(conn)
+	default:
+		conn.Close()
+		return "", errors.New("[WARN] no acceptable authentication method offered by client")
 	}
-	return nil
+}
+
+/*
+	Username/password sub-negotiation (RFC 1929)
+*/
+func client_authentication(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 1 {
+		conn.Close()
+		return "", errors.New("[WARN] client authentication failed")
+	}
+
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		conn.Close()
+		return "", errors.New("[WARN] client authentication failed")
+	}
+
+	password_length := make([]byte, 1)
+	if _, err := io.ReadFull(conn, password_length); err != nil {
+		conn.Close()
+		return "", errors.New("[WARN] client authentication failed")
+	}
+
+	password := make([]byte, password_length[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		conn.Close()
+		return "", errors.New("[WARN] client authentication failed")
+	}
+
+	if !authenticateProxyUser(string(username), string(password)) {
+		log.Printf("[WARN] SOCKS authentication failed for user %q from %s", string(username), get_source_ip(conn))
+		conn.Write([]byte{1, 1})
+		conn.Close()
+		return "", fmt.Errorf("[WARN] authentication failed for user %q", string(username))
+	}
+
+	if nWrite, err := conn.Write([]byte{1, 0}); err != nil || nWrite != 2 {
+		return "", errors.New("[WARN] client authentication reply failed")
+	}
+
+	return string(username), nil
 }
 
 /*
 
  */
-func client_conection_request(conn net.Conn) (string, error) {
+func client_conection_request(conn net.Conn) (byte, string, error) {
 	header := make([]byte, 4)
 	port := make([]byte, 2)
 	var address string
 
 	if nRead, err := conn.Read(header); err != nil || nRead != len(header) {
-		conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 		conn.Close()
-		return "", errors.New("[WARN] client connection request failed")
+		return 0, "", errors.New("[WARN] client connection request failed")
 	}
 
 	socks_version := header[0]
@@ -63,15 +155,15 @@ func client_conection_request(conn net.Conn) (string, error) {
 	address_type := header[3]
 
 	if socks_version != 5 {
-		conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 		conn.Close()
-		return "", errors.New("[WARN] unsupported SOCKS version")
+		return 0, "", errors.New("[WARN] unsupported SOCKS version")
 	}
 
-	if cmd_code != CONNECT {
-		conn.Write([]byte{5, COMMAND_NOT_SUPPORTED, 0, 1, 0, 0, 0, 0, 0, 0})
+	if cmd_code != CONNECT && cmd_code != BIND && cmd_code != UDP_ASSOCIATE {
+		conn.Write(build_socks_reply(COMMAND_NOT_SUPPORTED, nil))
 		conn.Close()
-		return "", errors.New("[WARN] unsupported command code")
+		return 0, "", errors.New("[WARN] unsupported command code")
 	}
 
 	log.Printf("[DEBUG] SOCKS5 request: version=%d, cmd=%d, addr_type=%d", socks_version, cmd_code, address_type)
@@ -81,15 +173,15 @@ func client_conection_request(conn net.Conn) (string, error) {
 		ipv4_address := make([]byte, 4)
 
 		if nRead, err := conn.Read(ipv4_address); err != nil || nRead != len(ipv4_address) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 
 		if nRead, err := conn.Read(port); err != nil || nRead != len(port) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 		ipStr := fmt.Sprintf("%d.%d.%d.%d", ipv4_address[0], ipv4_address[1], ipv4_address[2], ipv4_address[3])
 		port_num := binary.BigEndian.Uint16(port)
@@ -105,23 +197,23 @@ func client_conection_request(conn net.Conn) (string, error) {
 		domain_name_length := make([]byte, 1)
 
 		if nRead, err := conn.Read(domain_name_length); err != nil || nRead != len(domain_name_length) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 
 		domain_name := make([]byte, domain_name_length[0])
 
 		if nRead, err := conn.Read(domain_name); err != nil || nRead != len(domain_name) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 
 		if nRead, err := conn.Read(port); err != nil || nRead != len(port) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 		address = fmt.Sprintf("%s:%d", string(domain_name), binary.BigEndian.Uint16(port))
 
@@ -129,68 +221,144 @@ func client_conection_request(conn net.Conn) (string, error) {
 		ipv6_address := make([]byte, 16)
 
 		if nRead, err := conn.Read(ipv6_address); err != nil || nRead != len(ipv6_address) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 
 		if nRead, err := conn.Read(port); err != nil || nRead != len(port) {
-			conn.Write([]byte{5, SERVER_FAILURE, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Write(build_socks_reply(SERVER_FAILURE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] client connection request failed")
+			return 0, "", errors.New("[WARN] client connection request failed")
 		}
 		
 		// Format IPv6 address
 		ip := net.IP(ipv6_address)
 		ipStr := ip.String()
 		
-		// Check for invalid IPv6 addresses
-		if ipStr == "::" || ipStr == "::1" {
-			conn.Write([]byte{5, HOST_UNREACHABLE, 0, 1, 0, 0, 0, 0, 0, 0})
+		// Check for invalid IPv6 addresses (the unspecified address is valid for UDP ASSOCIATE)
+		if cmd_code == CONNECT && (ipStr == "::" || ipStr == "::1") {
+			conn.Write(build_socks_reply(HOST_UNREACHABLE, nil))
 			conn.Close()
-			return "", errors.New("[WARN] invalid IPv6 address: " + ipStr)
+			return 0, "", errors.New("[WARN] invalid IPv6 address: " + ipStr)
 		}
 		
 		address = fmt.Sprintf("[%s]:%d", ipStr, binary.BigEndian.Uint16(port))
 
 	default:
-		conn.Write([]byte{5, ADDRTYPE_NOT_SUPPORTED, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Write(build_socks_reply(ADDRTYPE_NOT_SUPPORTED, nil))
 		conn.Close()
-		return "", errors.New("[WARN] unsupported address type")
+		return 0, "", errors.New("[WARN] unsupported address type")
+	}
+	return cmd_code, address, nil
+}
+
+/*
+	Builds a SOCKS5 reply carrying the given bound address (IPv4, IPv6 or domain)
+*/
+func build_socks_reply(status byte, addr net.Addr) []byte {
+	ip := net.IPv4zero
+	port := 0
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	case nil:
+	default:
+		host, port_str, err := net.SplitHostPort(a.String())
+		if err != nil {
+			break
+		}
+		port, _ = strconv.Atoi(port_str)
+		if parsed := net.ParseIP(host); parsed != nil {
+			ip = parsed
+		} else if len(host) > 0 && len(host) <= 255 {
+			reply := []byte{5, status, 0, DOMAIN, byte(len(host))}
+			reply = append(reply, host...)
+			return binary.BigEndian.AppendUint16(reply, uint16(port))
+		}
+	}
+
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+
+	reply := []byte{5, status, 0}
+	if ip4 := ip.To4(); ip4 != nil {
+		reply = append(reply, IPV4)
+		reply = append(reply, ip4...)
+	} else {
+		reply = append(reply, IPV6)
+		reply = append(reply, ip.To16()...)
 	}
-	return address, nil
+	return binary.BigEndian.AppendUint16(reply, uint16(port))
 }
 
 /*
 
  */
-func handle_socks_connection(conn net.Conn) (string, error) {
+func handle_socks_connection(conn net.Conn) (*proxy_request, error) {
 	// Set timeout for SOCKS handshake
 	conn.SetDeadline(time.Now().Add(10 * time.Second))
 
-	if _, _, err := client_greeting(conn); err != nil {
+	// VER plus NMETHODS (SOCKS5) or CD (SOCKS4)
+	header := make([]byte, 2)
+	if nRead, err := conn.Read(header); err != nil || nRead != len(header) {
+		err := errors.New("[WARN] client greeting failed")
 		if debug_mode {
 			log.Println(err)
 		}
-		return "", err
+		return nil, err
+	}
+
+	if header[0] == 4 {
+		request, err := client_socks4_request(conn, header[1])
+		if err != nil {
+			if debug_mode {
+				log.Println(err)
+			}
+			return nil, err
+		}
+
+		conn.SetDeadline(time.Time{})
+		return request, nil
 	}
 
-	if err := servers_choice(conn); err != nil {
+	if header[0] != 5 {
+		err := fmt.Errorf("[WARN] unsupported SOCKS version %d", header[0])
+		if debug_mode {
+			log.Println(err)
+		}
+		return nil, err
+	}
+
+	auth_methods, err := client_greeting(conn, header[1])
+	if err != nil {
+		if debug_mode {
+			log.Println(err)
+		}
+		return nil, err
+	}
+
+	username, err := servers_choice(conn, auth_methods)
+	if err != nil {
 		if debug_mode {
 			log.Println(err)
 		}
-		return "", err
+		return nil, err
 	}
 
-	address, err := client_conection_request(conn)
+	command, address, err := client_conection_request(conn)
 	if err != nil {
 		if debug_mode {
 			log.Println(err)
 		}
-		return "", err
+		return nil, err
 	}
 	
 	// Clear deadline after successful handshake
 	conn.SetDeadline(time.Time{})
-	return address, nil
+	return &proxy_request{protocol: "SOCKS5", command: command, address: address, username: username}, nil
 }
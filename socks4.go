@@ -0,0 +1,110 @@
+// socks4.go
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+)
+
+// Maximum length of the NUL terminated USERID and hostname fields
+const socks4_max_field_length = 255
+
+/*
+Read a NUL terminated SOCKS4 field (USERID or SOCKS4a hostname)
+*/
+func read_socks4_field(conn net.Conn) (string, error) {
+	field := make([]byte, 0, 32)
+	b := make([]byte, 1)
+
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(field), nil
+		}
+		if len(field) >= socks4_max_field_length {
+			return "", errors.New("field too long")
+		}
+		field = append(field, b[0])
+	}
+}
+
+/*
+Parse a SOCKS4/SOCKS4a request after VN and CD have been read.
+Request: DSTPORT(2) DSTIP(4) USERID NUL [HOSTNAME NUL]
+*/
+func client_socks4_request(conn net.Conn, cmd_code byte) (*proxy_request, error) {
+	request := &proxy_request{protocol: "SOCKS4", command: cmd_code}
+
+	port_ip := make([]byte, 6)
+	if _, err := io.ReadFull(conn, port_ip); err != nil {
+		conn.Close()
+		return nil, errors.New("[WARN] SOCKS4 request failed")
+	}
+
+	userid, err := read_socks4_field(conn)
+	if err != nil {
+		request.reply(conn, SERVER_FAILURE, nil)
+		conn.Close()
+		return nil, fmt.Errorf("[WARN] SOCKS4 request failed: userid: %v", err)
+	}
+	// The USERID is not authenticated, it is only logged
+
+	port := binary.BigEndian.Uint16(port_ip[0:2])
+	ip := net.IP(port_ip[2:6])
+
+	// SOCKS4a: DSTIP 0.0.0.x with x != 0 means a hostname follows the USERID
+	host := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		host, err = read_socks4_field(conn)
+		if err != nil || host == "" {
+			request.reply(conn, SERVER_FAILURE, nil)
+			conn.Close()
+			return nil, errors.New("[WARN] SOCKS4a request failed: invalid hostname")
+		}
+	}
+	request.address = net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	log.Printf("[DEBUG] SOCKS4 request: cmd=%d, addr=%s, userid=%q", cmd_code, request.address, userid)
+
+	if cmd_code != CONNECT && cmd_code != BIND {
+		request.reply(conn, COMMAND_NOT_SUPPORTED, nil)
+		conn.Close()
+		return nil, errors.New("[WARN] unsupported SOCKS4 command code")
+	}
+
+	// SOCKS4 has no password authentication
+	if currentSettings.SocksAuth {
+		request.reply(conn, CONNECTION_NOT_ALLOWED, nil)
+		conn.Close()
+		return nil, fmt.Errorf("[WARN] SOCKS4 request from %s refused: authentication required", get_source_ip(conn))
+	}
+
+	return request, nil
+}
+
+/*
+Builds a SOCKS4 reply: VN(0) CD DSTPORT DSTIP. Any SOCKS5 status other than
+SUCCESS is reported as rejected.
+*/
+func build_socks4_reply(status byte, addr net.Addr) []byte {
+	cd := byte(SOCKS4_REJECTED)
+	if status == SUCCESS {
+		cd = SOCKS4_GRANTED
+	}
+
+	reply := []byte{0, cd, 0, 0, 0, 0, 0, 0}
+	if a, ok := addr.(*net.TCPAddr); ok {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			binary.BigEndian.PutUint16(reply[2:4], uint16(a.Port))
+			copy(reply[4:8], ip4)
+		}
+	}
+	return reply
+}
@@ -0,0 +1,142 @@
+// socks_bind.go
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+)
+
+// How long a BIND listener waits for the remote peer to connect back
+const bind_accept_timeout = 2 * time.Minute
+
+// How long resolving the name of the expected BIND peer may take
+const bind_resolve_timeout = 10 * time.Second
+
+/*
+Addresses the remote peer of a BIND request may connect back from: the
+announced IP, the addresses of an announced name, or none when the client left
+it unspecified, then any peer is accepted
+*/
+func bind_expected_peer_ips(address string) ([]net.IP, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsUnspecified() {
+			return nil, nil
+		}
+		return []net.IP{ip}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bind_resolve_timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+/*
+Check whether a BIND peer connected from one of the expected addresses
+*/
+func bind_peer_expected(peer net.IP, expected_ips []net.IP) bool {
+	if expected_ips == nil {
+		return true
+	}
+	for _, ip := range expected_ips {
+		if peer.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Handle a SOCKS5 BIND request. A listener is opened on the selected load
+balancer so the remote peer connects back over the same uplink. The first
+reply carries the listening address, the second one the peer's address.
+*/
+func handle_socks_bind(conn net.Conn, request *proxy_request, source_ip string) {
+	// Only the announced peer may connect back; a name that cannot be resolved is refused
+	expected_ips, err := bind_expected_peer_ips(request.address)
+	if err != nil {
+		log.Printf("[WARN] BIND: could not resolve expected peer %s for %s: %v", request.address, source_ip, err)
+		request.reply(conn, HOST_UNREACHABLE, nil)
+		conn.Close()
+		return
+	}
+
+	load_balancer, i, err := get_enhanced_load_balancer(source_ip, request.address)
+	if err != nil {
+		request.reply(conn, dial_error_reply(err), nil)
+		conn.Close()
+		return
+	}
+
+	listener, err := listen_tcp_via_load_balancer(load_balancer)
+	if err != nil {
+		reply := record_load_balancer_failure(load_balancer, err)
+		log.Printf("[WARN] BIND: could not listen on %s (%s) LB: %d, Source: %s: %v",
+			load_balancer.address, load_balancer.iface, i, source_ip, err)
+		request.reply(conn, reply, nil)
+		conn.Close()
+		return
+	}
+	defer listener.Close()
+
+	// First reply: where the remote peer should connect to
+	if err := request.reply(conn, SUCCESS, listener.Addr()); err != nil {
+		conn.Close()
+		return
+	}
+
+	log.Printf("[DEBUG] BIND for %s listening on %s via LB: %d", source_ip, listener.Addr(), i)
+
+	listener.SetDeadline(time.Now().Add(bind_accept_timeout))
+
+	var peer *net.TCPConn
+	for {
+		peer, err = listener.AcceptTCP()
+		if err != nil {
+			// The peer did not connect back, which says nothing about the link
+			reply := record_peer_failure(load_balancer, err)
+			log.Printf("[WARN] BIND: no inbound connection for %s via LB: %d: %v", source_ip, i, err)
+			request.reply(conn, reply, nil)
+			conn.Close()
+			return
+		}
+
+		peer_addr := peer.RemoteAddr().(*net.TCPAddr)
+		if bind_peer_expected(peer_addr.IP, expected_ips) {
+			break
+		}
+
+		log.Printf("[WARN] BIND: rejected inbound connection from %s, expected %s (Source: %s)", peer_addr, request.address, source_ip)
+		peer.Close()
+	}
+
+	load_balancer.success_count++
+
+	// Second reply: who connected
+	if err := request.reply(conn, SUCCESS, peer.RemoteAddr()); err != nil {
+		peer.Close()
+		conn.Close()
+		return
+	}
+
+	log.Printf("[DEBUG] BIND for %s accepted %s via %s LB: %d", source_ip, peer.RemoteAddr(), load_balancer.address, i)
+
+	conn_id := add_active_connection(conn, peer.RemoteAddr().String(), load_balancer, i, request.username)
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.Protocol = request.protocol
+	})
+	pipe_connections(conn, peer, conn_id)
+}
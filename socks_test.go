@@ -0,0 +1,57 @@
+// socks_test.go
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// A net.Addr given as "host:port", such as the bound address of a relay by name
+type test_addr string
+
+func (a test_addr) Network() string { return "tcp" }
+func (a test_addr) String() string  { return string(a) }
+
+func TestBuildSocksReply(t *testing.T) {
+	long_host := strings.Repeat("a", 256)
+
+	tests := []struct {
+		name   string
+		status byte
+		addr   net.Addr
+		want   []byte
+	}{
+		{"no address", HOST_UNREACHABLE, nil,
+			[]byte{5, HOST_UNREACHABLE, 0, IPV4, 0, 0, 0, 0, 0, 0}},
+		{"IPv4 TCP", SUCCESS, &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 1080},
+			[]byte{5, SUCCESS, 0, IPV4, 192, 168, 1, 10, 0x04, 0x38}},
+		{"IPv4 in 4 byte form", SUCCESS, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 80},
+			[]byte{5, SUCCESS, 0, IPV4, 10, 0, 0, 1, 0, 80}},
+		{"IPv4 UDP", SUCCESS, &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53},
+			[]byte{5, SUCCESS, 0, IPV4, 10, 0, 0, 1, 0, 53}},
+		{"IPv6 TCP", SUCCESS, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			[]byte{5, SUCCESS, 0, IPV6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x01, 0xbb}},
+		{"IPv6 UDP", SUCCESS, &net.UDPAddr{IP: net.IPv6loopback, Port: 5353},
+			[]byte{5, SUCCESS, 0, IPV6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x14, 0xe9}},
+		{"address without IP", SUCCESS, &net.TCPAddr{Port: 8080},
+			[]byte{5, SUCCESS, 0, IPV4, 0, 0, 0, 0, 0x1f, 0x90}},
+		{"domain", SUCCESS, test_addr("proxy.example.com:8080"),
+			append(append([]byte{5, SUCCESS, 0, DOMAIN, 17}, "proxy.example.com"...), 0x1f, 0x90)},
+		{"IP given as text", SUCCESS, test_addr("[2001:db8::1]:443"),
+			[]byte{5, SUCCESS, 0, IPV6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x01, 0xbb}},
+		{"domain too long", SUCCESS, test_addr(long_host + ":80"),
+			[]byte{5, SUCCESS, 0, IPV4, 0, 0, 0, 0, 0, 80}},
+		{"unparsable address", SERVER_FAILURE, test_addr("no port"),
+			[]byte{5, SERVER_FAILURE, 0, IPV4, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := build_socks_reply(tt.status, tt.addr); !bytes.Equal(got, tt.want) {
+				t.Errorf("build_socks_reply(%d, %v) = %v, want %v", tt.status, tt.addr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+// socks_udp.go
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+)
+
+// Maximum size of a relayed UDP datagram including the SOCKS5 header
+const udp_buffer_size = 64 * 1024
+
+// Number of destinations an association remembers the resolved address of
+const udp_resolve_cache_size = 256
+
+// Number of remote addresses an association accepts datagrams from
+const udp_max_peers = 1024
+
+// Number of destinations an association remembers the ACL verdict of
+const udp_acl_cache_size = 256
+
+/*
+Key of a UDP address, with IPv4-mapped IPv6 addresses in their IPv4 form
+*/
+func udp_peer_key(addr *net.UDPAddr) netip.AddrPort {
+	addr_port := addr.AddrPort()
+	return netip.AddrPortFrom(addr_port.Addr().Unmap(), addr_port.Port())
+}
+
+/*
+Parse the SOCKS5 UDP request header (RFC 1928, section 7).
+Returns the destination address and the payload.
+*/
+func parse_udp_datagram(datagram []byte) (string, []byte, error) {
+	if len(datagram) < 4 {
+		return "", nil, errors.New("datagram too short")
+	}
+
+	// RSV (2 bytes) must be zero, fragmentation is not supported
+	if datagram[2] != 0 {
+		return "", nil, fmt.Errorf("fragmented datagram (frag=%d) not supported", datagram[2])
+	}
+
+	var host string
+	offset := 4
+
+	switch datagram[3] {
+	case IPV4:
+		if len(datagram) < offset+4+2 {
+			return "", nil, errors.New("truncated IPv4 header")
+		}
+		host = net.IP(datagram[offset : offset+4]).String()
+		offset += 4
+
+	case DOMAIN:
+		if len(datagram) < offset+1 {
+			return "", nil, errors.New("truncated domain header")
+		}
+		domain_length := int(datagram[offset])
+		offset++
+		if len(datagram) < offset+domain_length+2 {
+			return "", nil, errors.New("truncated domain header")
+		}
+		host = string(datagram[offset : offset+domain_length])
+		offset += domain_length
+
+	case IPV6:
+		if len(datagram) < offset+16+2 {
+			return "", nil, errors.New("truncated IPv6 header")
+		}
+		host = net.IP(datagram[offset : offset+16]).String()
+		offset += 16
+
+	default:
+		return "", nil, fmt.Errorf("unsupported address type %d", datagram[3])
+	}
+
+	port := binary.BigEndian.Uint16(datagram[offset : offset+2])
+	offset += 2
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), datagram[offset:], nil
+}
+
+/*
+Prepend the SOCKS5 UDP request header for a datagram received from addr
+*/
+func build_udp_datagram(addr *net.UDPAddr, payload []byte) []byte {
+	datagram := make([]byte, 0, 4+16+2+len(payload))
+	datagram = append(datagram, 0, 0, 0)
+
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		datagram = append(datagram, IPV4)
+		datagram = append(datagram, ip4...)
+	} else {
+		datagram = append(datagram, IPV6)
+		datagram = append(datagram, addr.IP.To16()...)
+	}
+
+	datagram = binary.BigEndian.AppendUint16(datagram, uint16(addr.Port))
+	return append(datagram, payload...)
+}
+
+/*
+Handle a SOCKS5 UDP ASSOCIATE request. Datagrams from the client are relayed
+through a UDP socket bound to the selected load balancer; only datagrams from
+remote addresses the client sent to are relayed back. The association lives as
+long as the controlling TCP connection.
+*/
+func handle_udp_associate(conn net.Conn, request *proxy_request, source_ip string) {
+	defer conn.Close()
+
+	load_balancer, i, err := get_enhanced_load_balancer(source_ip, request.address)
+	if err != nil {
+		request.reply(conn, dial_error_reply(err), nil)
+		return
+	}
+
+	// Socket facing the client, on the address the client reached us on
+	local_ip := net.IPv4zero
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		local_ip = addr.IP
+	}
+
+	client_udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: local_ip, Port: 0})
+	if err != nil {
+		log.Printf("[WARN] UDP associate: could not open client socket for %s: %v", source_ip, err)
+		request.reply(conn, SERVER_FAILURE, nil)
+		return
+	}
+	defer client_udp.Close()
+
+	// Socket facing the remote hosts, bound to the load balancer
+	remote_udp, err := listen_udp_via_load_balancer(load_balancer)
+	if err != nil {
+		reply := record_load_balancer_failure(load_balancer, err)
+		log.Printf("[WARN] UDP associate: could not bind relay to %s (%s) LB: %d, Source: %s: %v",
+			load_balancer.address, load_balancer.iface, i, source_ip, err)
+		request.reply(conn, reply, nil)
+		return
+	}
+	defer remote_udp.Close()
+
+	mutex.Lock()
+	load_balancer.success_count++
+	mutex.Unlock()
+
+	if err := request.reply(conn, SUCCESS, client_udp.LocalAddr()); err != nil {
+		return
+	}
+
+	conn_id := add_active_connection(conn, request.address, load_balancer, i, request.username)
+	update_active_connection(conn_id, func(ac *active_connection) {
+		ac.Protocol = "UDP"
+	})
+	defer remove_active_connection(conn_id)
+
+	log.Printf("[DEBUG] UDP association for %s relayed via %s LB: %d (client socket %s, relay socket %s)",
+		source_ip, load_balancer.address, i, client_udp.LocalAddr(), remote_udp.LocalAddr())
+
+	// Only accept datagrams from the client that owns the association. If the
+	// client announced its sending port, lock to it right away.
+	client_ip := net.ParseIP(source_ip)
+	var client_addr *net.UDPAddr
+	var client_mutex sync.Mutex
+	// Remote addresses the client sent to, the only ones replies are relayed from
+	peers := make(map[netip.AddrPort]bool)
+	if host, port, err := net.SplitHostPort(request.address); err == nil {
+		if p, _ := strconv.Atoi(port); p != 0 {
+			announced := net.ParseIP(host)
+			if announced == nil || announced.IsUnspecified() {
+				announced = client_ip
+			}
+			client_addr = &net.UDPAddr{IP: announced, Port: p}
+		}
+	}
+
+	// Client -> remote
+	go func() {
+		buffer := make([]byte, udp_buffer_size)
+		last_destination := ""
+		// Resolved addresses by destination, so a slow name only stalls the association once
+		resolved := make(map[string]*net.UDPAddr)
+		// ACL verdicts by destination and address, so each flow of the association is checked once
+		acl_verdicts := make(map[string]bool)
+		for {
+			n, from, err := client_udp.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			client_mutex.Lock()
+			if client_addr == nil && from.IP.Equal(client_ip) {
+				client_addr = from
+			}
+			accepted := client_addr != nil && from.IP.Equal(client_addr.IP) && from.Port == client_addr.Port
+			client_mutex.Unlock()
+
+			if !accepted {
+				if debug_mode {
+					log.Printf("[DEBUG] UDP association %s: dropping datagram from unexpected source %s", conn_id, from)
+				}
+				continue
+			}
+
+			destination, payload, err := parse_udp_datagram(buffer[:n])
+			if err != nil {
+				if debug_mode {
+					log.Printf("[DEBUG] UDP association %s: dropping datagram: %v", conn_id, err)
+				}
+				continue
+			}
+
+			remote_addr, cached := resolved[destination]
+			if !cached {
+				remote_addr, err = net.ResolveUDPAddr("udp", destination)
+				if err != nil {
+					if debug_mode {
+						log.Printf("[DEBUG] UDP association %s: could not resolve %s: %v", conn_id, destination, err)
+					}
+					continue
+				}
+				if len(resolved) >= udp_resolve_cache_size {
+					// Make room by forgetting an arbitrary destination
+					for key := range resolved {
+						delete(resolved, key)
+						break
+					}
+				}
+				resolved[destination] = remote_addr
+			}
+
+			// Checked on the address the datagram is sent to
+			acl_key := destination + " " + remote_addr.IP.String()
+			allowed, checked := acl_verdicts[acl_key]
+			if !checked {
+				allowed = check_proxy_acl_addresses(source_ip, destination, "UDP", []net.IP{remote_addr.IP})
+				if len(acl_verdicts) >= udp_acl_cache_size {
+					// Make room by forgetting an arbitrary verdict
+					for key := range acl_verdicts {
+						delete(acl_verdicts, key)
+						break
+					}
+				}
+				acl_verdicts[acl_key] = allowed
+			}
+			if !allowed {
+				continue
+			}
+
+			peer := udp_peer_key(remote_addr)
+			client_mutex.Lock()
+			if !peers[peer] {
+				if len(peers) >= udp_max_peers {
+					// Make room by forgetting an arbitrary remote address
+					for key := range peers {
+						delete(peers, key)
+						break
+					}
+				}
+				peers[peer] = true
+			}
+			client_mutex.Unlock()
+
+			written, err := remote_udp.WriteToUDP(payload, remote_addr)
+			if err != nil {
+				if debug_mode {
+					log.Printf("[DEBUG] UDP association %s: send to %s failed: %v", conn_id, remote_addr, err)
+				}
+				continue
+			}
+
+			if destination != last_destination {
+				last_destination = destination
+				host, port, _ := net.SplitHostPort(destination)
+				port_num, _ := strconv.Atoi(port)
+				update_active_connection(conn_id, func(ac *active_connection) {
+					ac.DestinationIP = host
+					ac.DestinationPort = port_num
+				})
+			}
+			update_connection_traffic(conn_id, 0, int64(written))
+		}
+	}()
+
+	// Remote -> client
+	go func() {
+		buffer := make([]byte, udp_buffer_size)
+		for {
+			n, from, err := remote_udp.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+
+			client_mutex.Lock()
+			target := client_addr
+			known := peers[udp_peer_key(from)]
+			client_mutex.Unlock()
+			if target == nil {
+				continue
+			}
+			if !known {
+				if debug_mode {
+					log.Printf("[DEBUG] UDP association %s: dropping datagram from %s, the client did not send to it", conn_id, from)
+				}
+				continue
+			}
+
+			if _, err := client_udp.WriteToUDP(build_udp_datagram(from, buffer[:n]), target); err != nil {
+				if debug_mode {
+					log.Printf("[DEBUG] UDP association %s: send to client failed: %v", conn_id, err)
+				}
+				continue
+			}
+			update_connection_traffic(conn_id, int64(n), 0)
+		}
+	}()
+
+	// The association terminates when the controlling TCP connection closes
+	io.Copy(io.Discard, conn)
+	log.Printf("[DEBUG] UDP association %s for %s closed", conn_id, source_ip)
+}
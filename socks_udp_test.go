@@ -0,0 +1,84 @@
+// socks_udp_test.go
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseUDPDatagram(t *testing.T) {
+	ipv6 := net.ParseIP("2001:db8::1").To16()
+
+	tests := []struct {
+		name        string
+		datagram    []byte
+		destination string
+		payload     []byte
+		ok          bool
+	}{
+		{"IPv4", []byte{0, 0, 0, IPV4, 8, 8, 8, 8, 0, 53, 'q', 'u', 'e', 'r', 'y'}, "8.8.8.8:53", []byte("query"), true},
+		{"IPv4 without payload", []byte{0, 0, 0, IPV4, 10, 0, 0, 1, 0x1f, 0x90}, "10.0.0.1:8080", []byte{}, true},
+		{"IPv6", append(append([]byte{0, 0, 0, IPV6}, ipv6...), 0x01, 0xbb, 'x'), "[2001:db8::1]:443", []byte("x"), true},
+		{"domain", append(append([]byte{0, 0, 0, DOMAIN, 11}, "example.com"...), 0, 53, 'x'), "example.com:53", []byte("x"), true},
+		{"empty", []byte{}, "", nil, false},
+		{"shorter than the fixed header", []byte{0, 0, 0}, "", nil, false},
+		{"no address", []byte{0, 0, 0, IPV4}, "", nil, false},
+		{"truncated IPv4 address", []byte{0, 0, 0, IPV4, 8, 8, 8}, "", nil, false},
+		{"truncated IPv4 port", []byte{0, 0, 0, IPV4, 8, 8, 8, 8, 0}, "", nil, false},
+		{"truncated IPv6 address", append([]byte{0, 0, 0, IPV6}, ipv6[:15]...), "", nil, false},
+		{"truncated IPv6 port", append(append([]byte{0, 0, 0, IPV6}, ipv6...), 1), "", nil, false},
+		{"domain without length", []byte{0, 0, 0, DOMAIN}, "", nil, false},
+		{"truncated domain", append([]byte{0, 0, 0, DOMAIN, 11}, "example"...), "", nil, false},
+		{"truncated domain port", append([]byte{0, 0, 0, DOMAIN, 11}, "example.com"...), "", nil, false},
+		{"first fragment", []byte{0, 0, 1, IPV4, 8, 8, 8, 8, 0, 53, 'x'}, "", nil, false},
+		{"last fragment", []byte{0, 0, 0x81, IPV4, 8, 8, 8, 8, 0, 53, 'x'}, "", nil, false},
+		{"unsupported address type", []byte{0, 0, 0, 2, 8, 8, 8, 8, 0, 53}, "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination, payload, err := parse_udp_datagram(tt.datagram)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parse_udp_datagram(%v) error = %v, want ok = %v", tt.datagram, err, tt.ok)
+			}
+			if destination != tt.destination || !bytes.Equal(payload, tt.payload) {
+				t.Errorf("parse_udp_datagram(%v) = %q, %q, want %q, %q", tt.datagram, destination, payload, tt.destination, tt.payload)
+			}
+		})
+	}
+}
+
+func TestBuildUDPDatagram(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    *net.UDPAddr
+		payload []byte
+		want    []byte
+	}{
+		{"IPv4", &net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}, []byte("answer"),
+			append([]byte{0, 0, 0, IPV4, 8, 8, 8, 8, 0, 53}, "answer"...)},
+		{"IPv4-mapped IPv6", &net.UDPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 8080}, nil,
+			[]byte{0, 0, 0, IPV4, 10, 0, 0, 1, 0x1f, 0x90}},
+		{"IPv6", &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, []byte("x"),
+			[]byte{0, 0, 0, IPV6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x01, 0xbb, 'x'}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := build_udp_datagram(tt.addr, tt.payload)
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("build_udp_datagram(%v) = %v, want %v", tt.addr, got, tt.want)
+			}
+
+			// A reply must parse back to the address it came from
+			destination, payload, err := parse_udp_datagram(got)
+			if err != nil {
+				t.Fatalf("parse_udp_datagram of a built datagram: %v", err)
+			}
+			if want := (&net.UDPAddr{IP: tt.addr.IP, Port: tt.addr.Port}).String(); destination != want || !bytes.Equal(payload, tt.payload) {
+				t.Errorf("round trip = %q, %q, want %q, %q", destination, payload, want, tt.payload)
+			}
+		})
+	}
+}
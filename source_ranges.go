@@ -0,0 +1,167 @@
+// source_ranges.go
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"strings"
+)
+
+/*
+Parse a source IP rule pattern: a single IP, a CIDR block (192.168.10.0/24) or
+an IP range (192.168.10.50-192.168.10.99). Returns the first and last address
+in 16 byte form.
+*/
+func parse_source_pattern(pattern string) (net.IP, net.IP, bool) {
+	pattern = strings.TrimSpace(pattern)
+
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return nil, nil, false
+		}
+		first := network.IP.To16()
+		last := make(net.IP, len(first))
+		mask := network.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12], mask...)
+		}
+		for i := range first {
+			last[i] = first[i] | ^mask[i]
+		}
+		return first, last, true
+	}
+
+	if from, to, found := strings.Cut(pattern, "-"); found {
+		first := net.ParseIP(strings.TrimSpace(from))
+		last := net.ParseIP(strings.TrimSpace(to))
+		if first == nil || last == nil || (first.To4() == nil) != (last.To4() == nil) {
+			return nil, nil, false
+		}
+		first, last = first.To16(), last.To16()
+		if bytes.Compare(first, last) > 0 {
+			return nil, nil, false
+		}
+		return first, last, true
+	}
+
+	ip := net.ParseIP(pattern)
+	if ip == nil {
+		return nil, nil, false
+	}
+	return ip.To16(), ip.To16(), true
+}
+
+/*
+Check the syntax of a source IP rule pattern
+*/
+func valid_source_pattern(pattern string) bool {
+	_, _, ok := parse_source_pattern(pattern)
+	return ok
+}
+
+/*
+Canonical form of a valid source IP rule pattern, so the same block is not
+stored twice: CIDR blocks are reduced to their network address and spaces
+around a range are removed
+*/
+func normalize_source_pattern(pattern string) string {
+	pattern = strings.TrimSpace(pattern)
+	if strings.Contains(pattern, "/") {
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			return network.String()
+		}
+	}
+	if from, to, found := strings.Cut(pattern, "-"); found {
+		return strings.TrimSpace(from) + "-" + strings.TrimSpace(to)
+	}
+	if ip := net.ParseIP(pattern); ip != nil {
+		return ip.String()
+	}
+	return pattern
+}
+
+/*
+Number of addresses a pattern covers, used to rank overlapping rules
+*/
+func source_pattern_size(first net.IP, last net.IP) *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(last), new(big.Int).SetBytes(first))
+	return size.Add(size, big.NewInt(1))
+}
+
+/*
+Number of addresses of a pattern if it covers an IP (16 byte form)
+*/
+func source_pattern_covers(pattern string, ip net.IP) (*big.Int, bool) {
+	first, last, ok := parse_source_pattern(pattern)
+	if !ok || bytes.Compare(ip, first) < 0 || bytes.Compare(ip, last) > 0 {
+		return nil, false
+	}
+	return source_pattern_size(first, last), true
+}
+
+/*
+Check whether a pattern covering size addresses is more specific than the best
+match so far. Equal sizes are decided by the pattern text so the choice is stable.
+*/
+func more_specific_source_pattern(size *big.Int, pattern string, best_size *big.Int, best_pattern string) bool {
+	return best_size == nil || size.Cmp(best_size) < 0 || (size.Cmp(best_size) == 0 && pattern < best_pattern)
+}
+
+/*
+A parsed source IP rule pattern
+*/
+type source_range struct {
+	pattern string
+	first   net.IP   // first address in 16 byte form
+	last    net.IP   // last address in 16 byte form
+	size    *big.Int // number of addresses covered
+}
+
+/*
+Parse the patterns of the source IP rules of a load balancer, so picks match
+against ready ranges. Called with mutex held whenever the rules change.
+*/
+func index_source_ip_rules(lb *enhanced_load_balancer) {
+	ranges := make([]source_range, 0, len(lb.source_ip_rules))
+	for pattern := range lb.source_ip_rules {
+		if first, last, ok := parse_source_pattern(pattern); ok {
+			ranges = append(ranges, source_range{pattern, first, last, source_pattern_size(first, last)})
+		}
+	}
+	lb.source_ip_ranges = ranges
+}
+
+/*
+Find the rule for a source IP among rules keyed by pattern, with their parsed
+ranges. An exact IP rule wins, otherwise the matching CIDR block or range
+covering the fewest addresses, which is the longest prefix for CIDR blocks.
+*/
+func match_source_ip_rule(rules map[string]source_ip_rule, ranges []source_range, source_ip string) (source_ip_rule, bool) {
+	if rule, exists := rules[source_ip]; exists {
+		return rule, true
+	}
+
+	ip := net.ParseIP(source_ip)
+	if ip == nil {
+		return source_ip_rule{}, false
+	}
+	ip = ip.To16()
+
+	var best *source_range
+	for k := range ranges {
+		r := &ranges[k]
+		if bytes.Compare(ip, r.first) < 0 || bytes.Compare(ip, r.last) > 0 {
+			continue
+		}
+		if best == nil || more_specific_source_pattern(r.size, r.pattern, best.size, best.pattern) {
+			best = r
+		}
+	}
+	if best == nil {
+		return source_ip_rule{}, false
+	}
+	rule, exists := rules[best.pattern]
+	return rule, exists
+}
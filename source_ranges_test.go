@@ -0,0 +1,110 @@
+// source_ranges_test.go
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseSourcePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		first   string
+		last    string
+		ok      bool
+	}{
+		{"192.168.1.10", "192.168.1.10", "192.168.1.10", true},
+		{" 192.168.1.10 ", "192.168.1.10", "192.168.1.10", true},
+		{"2001:db8::1", "2001:db8::1", "2001:db8::1", true},
+		{"192.168.10.0/24", "192.168.10.0", "192.168.10.255", true},
+		{"192.168.10.77/24", "192.168.10.0", "192.168.10.255", true},
+		{"10.0.0.0/8", "10.0.0.0", "10.255.255.255", true},
+		{"192.168.1.10/32", "192.168.1.10", "192.168.1.10", true},
+		{"0.0.0.0/0", "0.0.0.0", "255.255.255.255", true},
+		{"2001:db8::/64", "2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", true},
+		{"192.168.10.50-192.168.10.99", "192.168.10.50", "192.168.10.99", true},
+		{"192.168.10.50 - 192.168.10.99", "192.168.10.50", "192.168.10.99", true},
+		{"192.168.10.50-192.168.10.50", "192.168.10.50", "192.168.10.50", true},
+		{"2001:db8::1-2001:db8::ff", "2001:db8::1", "2001:db8::ff", true},
+		{"192.168.10.99-192.168.10.50", "", "", false},
+		{"192.168.10.1-2001:db8::1", "", "", false},
+		{"192.168.10.1-", "", "", false},
+		{"192.168.10.0/33", "", "", false},
+		{"192.168.10.*", "", "", false},
+		{"*", "", "", false},
+		{"host.example.com", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			first, last, ok := parse_source_pattern(tt.pattern)
+			if ok != tt.ok {
+				t.Fatalf("parse_source_pattern(%q) ok = %v, want %v", tt.pattern, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if len(first) != net.IPv6len || len(last) != net.IPv6len {
+				t.Errorf("parse_source_pattern(%q) returned %d and %d byte addresses, want 16", tt.pattern, len(first), len(last))
+			}
+			if !first.Equal(net.ParseIP(tt.first)) || !last.Equal(net.ParseIP(tt.last)) {
+				t.Errorf("parse_source_pattern(%q) = %s - %s, want %s - %s", tt.pattern, first, last, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestMatchSourceIPRule(t *testing.T) {
+	patterns := []string{
+		"0.0.0.0/0",
+		"192.168.0.0/16",
+		"192.168.10.0/24",
+		"192.168.10.96/28",
+		"192.168.10.50-192.168.10.99",
+		"192.168.10.60-192.168.10.69",
+		"192.168.10.70",
+		"10.1.0.0-10.1.255.255",
+		"10.1.0.0/16",
+		"2001:db8::/32",
+		"2001:db8::10-2001:db8::1f",
+	}
+	rules := make(map[string]source_ip_rule, len(patterns))
+	for i, pattern := range patterns {
+		rules[pattern] = source_ip_rule{SourceIP: pattern, ContentionRatio: i + 1}
+	}
+	lb := &enhanced_load_balancer{source_ip_rules: rules}
+	index_source_ip_rules(lb)
+
+	tests := []struct {
+		name      string
+		source_ip string
+		want      string
+	}{
+		{"exact IP beats every block", "192.168.10.70", "192.168.10.70"},
+		{"smaller range beats CIDR", "192.168.10.65", "192.168.10.60-192.168.10.69"},
+		{"CIDR beats larger range", "192.168.10.97", "192.168.10.96/28"},
+		{"range beats larger CIDR", "192.168.10.55", "192.168.10.50-192.168.10.99"},
+		{"longest prefix", "192.168.10.20", "192.168.10.0/24"},
+		{"shorter prefix", "192.168.20.1", "192.168.0.0/16"},
+		{"catch-all block", "172.16.0.1", "0.0.0.0/0"},
+		{"equal size decided by pattern text", "10.1.2.3", "10.1.0.0-10.1.255.255"},
+		{"IPv6 range", "2001:db8::15", "2001:db8::10-2001:db8::1f"},
+		{"IPv6 CIDR", "2001:db8::1:1", "2001:db8::/32"},
+		{"IPv4-mapped IPv6", "::ffff:192.168.10.20", "192.168.10.0/24"},
+		{"IPv6 outside every block", "2001:db9::1", ""},
+		{"not an IP", "client.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, exists := match_source_ip_rule(lb.source_ip_rules, lb.source_ip_ranges, tt.source_ip)
+			if exists != (tt.want != "") {
+				t.Fatalf("match_source_ip_rule(%q) matched = %v, want %q", tt.source_ip, exists, tt.want)
+			}
+			if exists && rule.SourceIP != tt.want {
+				t.Errorf("match_source_ip_rule(%q) = %q, want %q", tt.source_ip, rule.SourceIP, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// spillover.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Check whether a load balancer has a spillover threshold
+*/
+func spillover_configured(lb *enhanced_load_balancer) bool {
+	return lb.spill_utilization_pct > 0 || lb.spill_max_connections > 0
+}
+
+/*
+Spillover state of a load balancer from its utilization (the busier direction)
+and its open connections, and a description when it differs from the current
+state. Spilling starts when either reaches its threshold and stops once both are
+spillover_hysteresis_pct percent below their thresholds, so a link near its
+limit does not flap. Called with mutex held.
+*/
+func next_spillover_state(lb *enhanced_load_balancer) (bool, string) {
+	if !spillover_configured(lb) {
+		return false, ""
+	}
+
+	down, up := get_link_utilization(lb)
+	utilization := math.Max(down, up)
+	active := float64(atomic.LoadInt64(&lb.active_count))
+
+	// Reasons the link is at or above its thresholds scaled by factor
+	over := func(factor float64) []string {
+		var reasons []string
+		if lb.spill_utilization_pct > 0 && utilization >= float64(lb.spill_utilization_pct)*factor {
+			reasons = append(reasons, fmt.Sprintf("%.0f%% utilization", utilization))
+		}
+		if lb.spill_max_connections > 0 && active >= float64(lb.spill_max_connections)*factor {
+			reasons = append(reasons, fmt.Sprintf("%.0f connections", active))
+		}
+		return reasons
+	}
+
+	if !lb.spilling {
+		if reasons := over(1); len(reasons) > 0 {
+			return true, fmt.Sprintf("%s is full (%s), new connections go to the next link", lb.address, strings.Join(reasons, ", "))
+		}
+		return false, ""
+	}
+
+	if len(over(float64(100-currentSettings.SpilloverHysteresisPct)/100)) == 0 {
+		return false, fmt.Sprintf("%s is below its thresholds again", lb.address)
+	}
+	return true, ""
+}
+
+/*
+Update the spillover state of a load balancer from its current load.
+Called with mutex held.
+*/
+func update_spillover_state(lb *enhanced_load_balancer) {
+	spilling, change := next_spillover_state(lb)
+	if spilling == lb.spilling {
+		return
+	}
+	lb.spilling, lb.spill_since = spilling, time.Now()
+	if change != "" {
+		log.Printf("[INFO] Spillover: %s", change)
+	}
+}
+
+/*
+Spillover state of a load balancer after updating it from its current load.
+Called with mutex held.
+*/
+func current_spillover_state(lb *enhanced_load_balancer) bool {
+	update_spillover_state(lb)
+	return lb.spilling
+}
+
+/*
+Spillover state a load balancer would get from its current load, without
+updating it. Called with mutex held.
+*/
+func peek_spillover_state(lb *enhanced_load_balancer) bool {
+	spilling, _ := next_spillover_state(lb)
+	return spilling
+}
+
+/*
+Restrict candidate load balancers for spillover. Load balancers with thresholds
+are filled one at a time in list order: new connections go to the first one that
+is not spilling. When all of them spill, the load balancers without thresholds
+take new connections, or every candidate when all have thresholds.
+Without thresholds on any candidate the candidates are returned unchanged.
+Called with mutex held.
+*/
+func select_spillover_candidates(candidates []int) []int {
+	return spillover_candidates(candidates, current_spillover_state)
+}
+
+/*
+Like select_spillover_candidates, without updating the spillover state.
+Called with mutex held.
+*/
+func peek_spillover_candidates(candidates []int) []int {
+	return spillover_candidates(candidates, peek_spillover_state)
+}
+
+func spillover_candidates(candidates []int, spilling func(lb *enhanced_load_balancer) bool) []int {
+	var unlimited []int
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		lb_spilling := spilling(lb)
+		if !spillover_configured(lb) {
+			unlimited = append(unlimited, i)
+		} else if !lb_spilling {
+			return []int{i}
+		}
+	}
+
+	if len(unlimited) > 0 {
+		return unlimited
+	}
+	return candidates
+}
+
+/*
+Set the spillover thresholds of a load balancer and persist them
+*/
+func set_load_balancer_spillover(lb_address string, utilization_pct int, max_connections int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range lb_list {
+		if lb_list[i].address == lb_address {
+			lb_list[i].spill_utilization_pct = utilization_pct
+			lb_list[i].spill_max_connections = max_connections
+			update_spillover_state(&lb_list[i])
+
+			if err := saveLoadBalancerSpillover(lb_address, utilization_pct, max_connections); err != nil {
+				log.Printf("[WARN] Failed to save spillover of load balancer %s: %v", lb_address, err)
+			}
+			log.Printf("[INFO] Spillover of load balancer %s set to %d%% utilization, %d connections", lb_address, utilization_pct, max_connections)
+			return true
+		}
+	}
+
+	log.Printf("[WARN] Load balancer %s not found", lb_address)
+	return false
+}
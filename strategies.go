@@ -0,0 +1,420 @@
+// strategies.go
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+/*
+A load-balancing strategy picks one of the candidate load balancers (indices
+into lb_list that are enabled and not excluded by a retry) for a source IP.
+Strategies are called with mutex held and may keep state between calls.
+peek tells which load balancer pick would return without changing any state,
+or -1 when the choice is random.
+*/
+type lb_strategy interface {
+	pick(source_ip string, candidates []int) int
+	peek(source_ip string, candidates []int) int
+}
+
+/*
+Strategies keeping state per source IP drop the state of sources that have not
+picked since cutoff. Called with mutex held.
+*/
+type lb_strategy_purger interface {
+	purge_idle(cutoff time.Time)
+}
+
+// Strategy names in the order they are offered in the settings
+var lb_strategy_names = []string{"ratio", "least_conn", "smooth_wrr", "weighted_random", "source_hash", "least_latency", "headroom"}
+
+// Strategy implementations by name
+var lb_strategies = map[string]lb_strategy{
+	"ratio":           &ratio_strategy{},
+	"least_conn":      &least_conn_strategy{},
+	"smooth_wrr":      new_smooth_wrr_strategy(),
+	"weighted_random": &weighted_random_strategy{},
+	"source_hash":     &source_hash_strategy{},
+	"least_latency":   &least_latency_strategy{band: new_smooth_wrr_strategy()},
+	"headroom":        &headroom_strategy{},
+}
+
+/*
+Drop per source IP strategy state of sources idle for idle_timeout
+*/
+func purge_idle_strategy_state() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cutoff := time.Now().Add(-idle_timeout)
+	for _, strategy := range lb_strategies {
+		if purger, ok := strategy.(lb_strategy_purger); ok {
+			purger.purge_idle(cutoff)
+		}
+	}
+}
+
+/*
+Check whether a strategy name is supported
+*/
+func valid_lb_strategy(name string) bool {
+	_, exists := lb_strategies[name]
+	return exists
+}
+
+/*
+Strategy name for a source IP: the strategy of its source IP rule if one sets
+it (the first load balancer's rule wins), then the global setting, then "ratio".
+*/
+func get_source_ip_strategy(source_ip string) string {
+	for i := range lb_list {
+		if rule, exists := get_source_ip_rule(&lb_list[i], source_ip); exists && rule.Strategy != "" {
+			return rule.Strategy
+		}
+	}
+	if valid_lb_strategy(currentSettings.LBStrategy) {
+		return currentSettings.LBStrategy
+	}
+	return "ratio"
+}
+
+/*
+Weight of a load balancer for a source IP, taken from its contention ratio
+*/
+func get_strategy_weight(lb *enhanced_load_balancer, source_ip string) int {
+	if weight := get_effective_contention_ratio(lb, source_ip); weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+func contains_index(indices []int, index int) bool {
+	for _, i := range indices {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Contention ratio round robin: consecutive bursts of contention_ratio connections
+per load balancer, tracked per source IP.
+*/
+type ratio_strategy struct{}
+
+/*
+Position of the round robin for a source IP: its own index, or the global one
+*/
+func (s *ratio_strategy) current_index(source_ip string) int {
+	current_index, exists := source_lb_indices[source_ip]
+	if !exists || current_index >= len(lb_list) {
+		current_index = lb_index % len(lb_list)
+	}
+	return current_index
+}
+
+func (s *ratio_strategy) peek(source_ip string, candidates []int) int {
+	current_index := s.current_index(source_ip)
+	for !contains_index(candidates, current_index) {
+		current_index = (current_index + 1) % len(lb_list)
+	}
+	return current_index
+}
+
+func (s *ratio_strategy) pick(source_ip string, candidates []int) int {
+	// Get source-specific index or use global index
+	current_index := s.current_index(source_ip)
+
+	// Skip load balancers that cannot be used, restarting their burst
+	for !contains_index(candidates, current_index) {
+		lb := &lb_list[current_index]
+		if lb.source_ip_counters == nil {
+			lb.source_ip_counters = make(map[string]int)
+		}
+		lb.source_ip_counters[source_ip] = 0
+		current_index = (current_index + 1) % len(lb_list)
+	}
+	source_lb_indices[source_ip] = current_index
+
+	lb := &lb_list[current_index]
+	if lb.source_ip_counters == nil {
+		lb.source_ip_counters = make(map[string]int)
+	}
+
+	lb.source_ip_counters[source_ip]++
+	lb.current_connections++
+
+	selected := current_index
+
+	// Check if we need to move to next load balancer
+	if lb.source_ip_counters[source_ip] >= get_effective_contention_ratio(lb, source_ip) {
+		lb.source_ip_counters[source_ip] = 0
+		source_lb_indices[source_ip] = (current_index + 1) % len(lb_list)
+	}
+
+	// Update global index as well
+	if lb.current_connections >= get_base_contention_ratio(lb) {
+		lb.current_connections = 0
+		lb_index = (lb_index + 1) % len(lb_list)
+	}
+
+	return selected
+}
+
+/*
+Least active connections relative to the weight of each load balancer.
+Ties rotate so simultaneous new connections do not all land on the same link.
+*/
+type least_conn_strategy struct {
+	next int
+}
+
+func (s *least_conn_strategy) pick(source_ip string, candidates []int) int {
+	best := s.peek(source_ip, candidates)
+	s.next++
+	return best
+}
+
+func (s *least_conn_strategy) peek(source_ip string, candidates []int) int {
+	start := s.next % len(candidates)
+
+	best := -1
+	var best_active int64
+	var best_weight int64
+	for k := range candidates {
+		i := candidates[(start+k)%len(candidates)]
+		active := atomic.LoadInt64(&lb_list[i].active_count)
+		weight := int64(get_strategy_weight(&lb_list[i], source_ip))
+
+		// active/weight < best_active/best_weight without dividing
+		if best < 0 || active*best_weight < best_active*weight {
+			best, best_active, best_weight = i, active, weight
+		}
+	}
+	return best
+}
+
+/*
+Smooth weighted round robin (as in nginx): every pick raises each candidate's
+current weight by its weight and lowers the winner's by the total, which
+interleaves links instead of sending bursts to one of them.
+Current weights are kept per source IP and load balancer address.
+*/
+type smooth_wrr_strategy struct {
+	current   map[string]map[string]int
+	last_pick map[string]time.Time // source_ip -> time of its last pick
+}
+
+/*
+Returns a smooth weighted round robin without current weights
+*/
+func new_smooth_wrr_strategy() *smooth_wrr_strategy {
+	return &smooth_wrr_strategy{current: make(map[string]map[string]int), last_pick: make(map[string]time.Time)}
+}
+
+func (s *smooth_wrr_strategy) pick(source_ip string, candidates []int) int {
+	current := s.current[source_ip]
+	if current == nil {
+		current = make(map[string]int)
+		s.current[source_ip] = current
+	}
+	s.last_pick[source_ip] = time.Now()
+
+	best := -1
+	total := 0
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		weight := get_strategy_weight(lb, source_ip)
+		current[lb.address] += weight
+		total += weight
+
+		if best < 0 || current[lb.address] > current[lb_list[best].address] {
+			best = i
+		}
+	}
+	current[lb_list[best].address] -= total
+	return best
+}
+
+func (s *smooth_wrr_strategy) peek(source_ip string, candidates []int) int {
+	best := -1
+	best_weight := 0
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		weight := s.current[source_ip][lb.address] + get_strategy_weight(lb, source_ip)
+		if best < 0 || weight > best_weight {
+			best, best_weight = i, weight
+		}
+	}
+	return best
+}
+
+func (s *smooth_wrr_strategy) purge_idle(cutoff time.Time) {
+	for source_ip, last := range s.last_pick {
+		if last.Before(cutoff) {
+			delete(s.current, source_ip)
+			delete(s.last_pick, source_ip)
+		}
+	}
+}
+
+/*
+Weighted random choice
+*/
+type weighted_random_strategy struct{}
+
+func (s *weighted_random_strategy) pick(source_ip string, candidates []int) int {
+	total := 0
+	for _, i := range candidates {
+		total += get_strategy_weight(&lb_list[i], source_ip)
+	}
+
+	n := rand.Intn(total)
+	for _, i := range candidates {
+		n -= get_strategy_weight(&lb_list[i], source_ip)
+		if n < 0 {
+			return i
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (s *weighted_random_strategy) peek(source_ip string, candidates []int) int {
+	return -1
+}
+
+/*
+Source hash: a source IP always uses the same load balancer while it is
+available. Weighted rendezvous hashing is used so a failing or disabled link
+only moves its own clients.
+*/
+type source_hash_strategy struct{}
+
+func (s *source_hash_strategy) peek(source_ip string, candidates []int) int {
+	return s.pick(source_ip, candidates)
+}
+
+func (s *source_hash_strategy) pick(source_ip string, candidates []int) int {
+	best := -1
+	best_score := 0.0
+	for _, i := range candidates {
+		lb := &lb_list[i]
+
+		h := fnv.New64a()
+		h.Write([]byte(source_ip))
+		h.Write([]byte{0})
+		h.Write([]byte(lb.address))
+
+		// Uniform value in (0, 1) from the top 53 bits of the hash
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := float64(get_strategy_weight(lb, source_ip)) / -math.Log(u)
+
+		if best < 0 || score > best_score {
+			best, best_score = i, score
+		}
+	}
+	return best
+}
+
+/*
+Least latency: prefer the load balancers with the lowest average connect time.
+Links within latency_tolerance_ms of the fastest one share the load by weight,
+links not measured yet are included so they get a figure.
+*/
+type least_latency_strategy struct {
+	band *smooth_wrr_strategy
+}
+
+func (s *least_latency_strategy) pick(source_ip string, candidates []int) int {
+	return s.band.pick(source_ip, s.fastest_band(candidates))
+}
+
+func (s *least_latency_strategy) peek(source_ip string, candidates []int) int {
+	return s.band.peek(source_ip, s.fastest_band(candidates))
+}
+
+/*
+Candidates within latency_tolerance_ms of the fastest one, and the ones not measured yet
+*/
+func (s *least_latency_strategy) fastest_band(candidates []int) []int {
+	fastest := -1.0
+	for _, i := range candidates {
+		if lb := &lb_list[i]; lb.latency_samples > 0 && (fastest < 0 || lb.connect_latency < fastest) {
+			fastest = lb.connect_latency
+		}
+	}
+
+	band := make([]int, 0, len(candidates))
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		if lb.latency_samples == 0 || lb.connect_latency <= fastest+float64(currentSettings.LatencyToleranceMs) {
+			band = append(band, i)
+		}
+	}
+	return band
+}
+
+func (s *least_latency_strategy) purge_idle(cutoff time.Time) {
+	s.band.purge_idle(cutoff)
+}
+
+/*
+Headroom: prefer the load balancer with the most spare capacity, its configured
+capacity minus its current traffic rate, in the tighter of both directions.
+Links without a configured capacity count as having no headroom, so they are
+used once every configured link is saturated. Ties go to the least active link.
+*/
+type headroom_strategy struct {
+	ties least_conn_strategy
+}
+
+func (s *headroom_strategy) pick(source_ip string, candidates []int) int {
+	return s.ties.pick(source_ip, s.most_headroom(candidates))
+}
+
+func (s *headroom_strategy) peek(source_ip string, candidates []int) int {
+	return s.ties.peek(source_ip, s.most_headroom(candidates))
+}
+
+/*
+Candidates with the most spare capacity
+*/
+func (s *headroom_strategy) most_headroom(candidates []int) []int {
+	get_headroom := func(lb *enhanced_load_balancer) int64 {
+		if lb.capacity_down_mbps <= 0 && lb.capacity_up_mbps <= 0 {
+			return 0
+		}
+
+		headroom := int64(math.MaxInt64)
+		if lb.capacity_down_mbps > 0 {
+			headroom = int64(lb.capacity_down_mbps)*1000000 - lb.bytes_in_per_second*8
+		}
+		if lb.capacity_up_mbps > 0 {
+			if up := int64(lb.capacity_up_mbps)*1000000 - lb.bytes_out_per_second*8; up < headroom {
+				headroom = up
+			}
+		}
+		if headroom < 0 {
+			return 0
+		}
+		return headroom
+	}
+
+	var best []int
+	var best_headroom int64
+	for _, i := range candidates {
+		headroom := get_headroom(&lb_list[i])
+		switch {
+		case best == nil || headroom > best_headroom:
+			best, best_headroom = []int{i}, headroom
+		case headroom == best_headroom:
+			best = append(best, i)
+		}
+	}
+	return best
+}
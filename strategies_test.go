@@ -0,0 +1,215 @@
+// strategies_test.go
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+/*
+Replace lb_list with load balancers of the given contention ratios, addressed
+10.0.0.1, 10.0.0.2, ... and restore it when the test ends. mutex is held for
+the whole test, as strategies are called with it held.
+*/
+func set_test_load_balancers(t *testing.T, ratios ...int) {
+	t.Helper()
+	mutex.Lock()
+	saved := lb_list
+	t.Cleanup(func() {
+		lb_list = saved
+		mutex.Unlock()
+	})
+
+	lb_list = make([]enhanced_load_balancer, len(ratios))
+	for i, ratio := range ratios {
+		lb_list[i] = enhanced_load_balancer{
+			address:          fmt.Sprintf("10.0.0.%d:0", i+1),
+			contention_ratio: ratio,
+			enabled:          true,
+		}
+	}
+}
+
+func all_candidates() []int {
+	candidates := make([]int, len(lb_list))
+	for i := range candidates {
+		candidates[i] = i
+	}
+	return candidates
+}
+
+func TestSmoothWRRInterleaves(t *testing.T) {
+	tests := []struct {
+		name   string
+		ratios []int
+		want   []int
+	}{
+		{"equal weights", []int{1, 1, 1}, []int{0, 1, 2, 0, 1, 2}},
+		{"5:1:1", []int{5, 1, 1}, []int{0, 0, 1, 0, 2, 0, 0}},
+		{"2:1", []int{2, 1}, []int{0, 1, 0, 0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set_test_load_balancers(t, tt.ratios...)
+			strategy := new_smooth_wrr_strategy()
+
+			for n, want := range tt.want {
+				if peeked := strategy.peek("192.168.1.10", all_candidates()); peeked != want {
+					t.Errorf("peek %d = %d, want %d", n, peeked, want)
+				}
+				if got := strategy.pick("192.168.1.10", all_candidates()); got != want {
+					t.Fatalf("pick %d = %d, want %d", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSmoothWRRDistribution(t *testing.T) {
+	tests := []struct {
+		name   string
+		ratios []int
+		rounds int
+	}{
+		{"3:2:1", []int{3, 2, 1}, 10},
+		{"10:1", []int{10, 1}, 5},
+		{"1:4:4", []int{1, 4, 4}, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set_test_load_balancers(t, tt.ratios...)
+			strategy := new_smooth_wrr_strategy()
+
+			total := 0
+			for _, ratio := range tt.ratios {
+				total += ratio
+			}
+			counts := make([]int, len(tt.ratios))
+			for n := 0; n < total*tt.rounds; n++ {
+				counts[strategy.pick("192.168.1.10", all_candidates())]++
+			}
+
+			for i, ratio := range tt.ratios {
+				if want := ratio * tt.rounds; counts[i] != want {
+					t.Errorf("load balancer %d picked %d times, want %d", i, counts[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSmoothWRRKeepsSourcesApart(t *testing.T) {
+	set_test_load_balancers(t, 1, 1)
+	strategy := new_smooth_wrr_strategy()
+
+	if got := strategy.pick("192.168.1.10", all_candidates()); got != 0 {
+		t.Fatalf("first pick of 192.168.1.10 = %d, want 0", got)
+	}
+	if got := strategy.pick("192.168.1.11", all_candidates()); got != 0 {
+		t.Fatalf("first pick of 192.168.1.11 = %d, want 0", got)
+	}
+	if got := strategy.pick("192.168.1.10", all_candidates()); got != 1 {
+		t.Fatalf("second pick of 192.168.1.10 = %d, want 1", got)
+	}
+}
+
+func TestLeastConnTieRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		ratios []int
+		active []int64
+		want   []int
+	}{
+		{"all idle", []int{1, 1, 1}, []int64{0, 0, 0}, []int{0, 1, 2, 0, 1, 2}},
+		{"tie between two", []int{1, 1, 1}, []int64{2, 1, 1}, []int{1, 1, 2, 1}},
+		{"weighted tie", []int{2, 1}, []int64{2, 1}, []int{0, 1, 0, 1}},
+		{"no tie", []int{1, 1, 1}, []int64{3, 0, 2}, []int{1, 1, 1}},
+		{"weight breaks the tie", []int{3, 1}, []int64{2, 1}, []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set_test_load_balancers(t, tt.ratios...)
+			for i, active := range tt.active {
+				lb_list[i].active_count = active
+			}
+			strategy := &least_conn_strategy{}
+
+			for n, want := range tt.want {
+				if peeked := strategy.peek("192.168.1.10", all_candidates()); peeked != want {
+					t.Errorf("peek %d = %d, want %d", n, peeked, want)
+				}
+				if got := strategy.pick("192.168.1.10", all_candidates()); got != want {
+					t.Fatalf("pick %d = %d, want %d", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceHashStableWhenLinkRemoved(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratios  []int
+		removed int
+	}{
+		{"first of three", []int{1, 1, 1}, 0},
+		{"middle of four", []int{1, 1, 1, 1}, 1},
+		{"last of four", []int{1, 1, 1, 1}, 3},
+		{"weighted", []int{4, 1, 2}, 2},
+	}
+
+	sources := make([]string, 256)
+	for i := range sources {
+		sources[i] = fmt.Sprintf("192.168.%d.%d", i/16, i%16+1)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set_test_load_balancers(t, tt.ratios...)
+			strategy := &source_hash_strategy{}
+
+			before := make(map[string]string)
+			for _, source := range sources {
+				before[source] = lb_list[strategy.pick(source, all_candidates())].address
+				if again := lb_list[strategy.pick(source, all_candidates())].address; again != before[source] {
+					t.Fatalf("%s moved from %s to %s without any change", source, before[source], again)
+				}
+			}
+
+			// Excluding the link from the candidates and removing it from lb_list
+			// must both only move the sources that used it
+			candidates := make([]int, 0, len(lb_list)-1)
+			for _, i := range all_candidates() {
+				if i != tt.removed {
+					candidates = append(candidates, i)
+				}
+			}
+			removed := lb_list[tt.removed].address
+
+			moved := 0
+			for _, source := range sources {
+				after := lb_list[strategy.pick(source, candidates)].address
+				if before[source] != removed && after != before[source] {
+					t.Errorf("%s moved from %s to %s although %s was removed", source, before[source], after, removed)
+				}
+				if before[source] == removed {
+					moved++
+				}
+			}
+			if moved == 0 {
+				t.Fatalf("no source used %s, the test does not exercise the removal", removed)
+			}
+
+			lb_list = append(lb_list[:tt.removed:tt.removed], lb_list[tt.removed+1:]...)
+			for _, source := range sources {
+				after := lb_list[strategy.pick(source, all_candidates())].address
+				if before[source] != removed && after != before[source] {
+					t.Errorf("%s moved from %s to %s after %s was deleted", source, before[source], after, removed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// tiers.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// A load balancer is unhealthy after this many failed dials in a row
+const tier_failure_threshold = 3
+
+// How often an unhealthy load balancer of a better tier gets a probe connection
+const tier_probe_interval = 30 * time.Second
+
+// Number of failover and failback events kept for the dashboard
+const max_tier_events = 50
+
+/*
+A change of the tier serving new connections
+*/
+type tier_event struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"` // "failover" or "failback"
+	FromTier int       `json:"from_tier"`
+	ToTier   int       `json:"to_tier"` // 0 when no tier has a healthy load balancer
+	Reason   string    `json:"reason"`
+}
+
+// Tier currently serving new connections (0 = none healthy) and recent changes, protected by mutex
+var active_tier int
+var tier_evaluated bool
+var tier_events []tier_event
+
+/*
+Check whether a load balancer can take new connections: enabled and not failing
+*/
+func load_balancer_healthy(lb *enhanced_load_balancer) bool {
+	return lb.enabled && lb.consecutive_failures < tier_failure_threshold
+}
+
+/*
+Track the best tier with a healthy load balancer and record failover and
+failback events when it changes. Called with mutex held.
+*/
+func update_active_tier() {
+	best := 0
+	var unhealthy []string
+	for i := range lb_list {
+		lb := &lb_list[i]
+		if !lb.enabled {
+			continue
+		}
+		if !load_balancer_healthy(lb) {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (tier %d)", lb.address, lb.tier))
+			continue
+		}
+		if best == 0 || lb.tier < best {
+			best = lb.tier
+		}
+	}
+
+	if !tier_evaluated {
+		active_tier, tier_evaluated = best, true
+		return
+	}
+	if best == active_tier {
+		return
+	}
+
+	event := tier_event{Time: time.Now(), FromTier: active_tier, ToTier: best}
+	if best == 0 || (active_tier != 0 && best > active_tier) {
+		event.Kind = "failover"
+		event.Reason = "unhealthy: " + strings.Join(unhealthy, ", ")
+		log.Printf("[WARN] Failover from tier %d to tier %d, %s", event.FromTier, event.ToTier, event.Reason)
+	} else {
+		event.Kind = "failback"
+		event.Reason = fmt.Sprintf("tier %d is healthy again", best)
+		log.Printf("[INFO] Failback from tier %d to tier %d", event.FromTier, event.ToTier)
+	}
+
+	tier_events = append(tier_events, event)
+	if len(tier_events) > max_tier_events {
+		tier_events = tier_events[len(tier_events)-max_tier_events:]
+	}
+	active_tier = best
+}
+
+/*
+Restrict candidate load balancers to the best tier with a healthy member.
+While a better tier is down, one connection per probe interval is sent to an
+unhealthy load balancer of that tier; when it connects, the tier fails back.
+When no candidate is healthy all candidates of the best tier are tried.
+Called with mutex held.
+*/
+func select_tier_candidates(candidates []int) []int {
+	update_active_tier()
+
+	tiered, probe := get_tier_candidates(candidates)
+	if probe >= 0 {
+		lb := &lb_list[probe]
+		lb.last_failure = time.Now()
+		log.Printf("[INFO] Probing %s (tier %d) after %d failures", lb.address, lb.tier, lb.consecutive_failures)
+		return []int{probe}
+	}
+	return tiered
+}
+
+/*
+Candidates of the best tier with a healthy member (all of the best tier when
+none is healthy), and an unhealthy load balancer of a better tier that is due
+for a probe or -1. Called with mutex held.
+*/
+func get_tier_candidates(candidates []int) ([]int, int) {
+	best := 0
+	for _, i := range candidates {
+		if lb := &lb_list[i]; load_balancer_healthy(lb) && (best == 0 || lb.tier < best) {
+			best = lb.tier
+		}
+	}
+
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		if !load_balancer_healthy(lb) && (best == 0 || lb.tier < best) && time.Since(lb.last_failure) >= tier_probe_interval {
+			return nil, i
+		}
+	}
+
+	healthy_only := best != 0
+	if !healthy_only {
+		for _, i := range candidates {
+			if best == 0 || lb_list[i].tier < best {
+				best = lb_list[i].tier
+			}
+		}
+	}
+
+	tiered := make([]int, 0, len(candidates))
+	for _, i := range candidates {
+		lb := &lb_list[i]
+		if lb.tier == best && (!healthy_only || load_balancer_healthy(lb)) {
+			tiered = append(tiered, i)
+		}
+	}
+	return tiered, -1
+}
+
+/*
+Active tier and recent failover events, newest first
+*/
+func get_tier_status() (int, []tier_event) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	events := make([]tier_event, len(tier_events))
+	for i, event := range tier_events {
+		events[len(tier_events)-1-i] = event
+	}
+	return active_tier, events
+}
+
+/*
+Set the tier of a load balancer and persist it
+*/
+func set_load_balancer_tier(lb_address string, tier int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range lb_list {
+		if lb_list[i].address == lb_address {
+			lb_list[i].tier = tier
+
+			if err := saveLoadBalancerTier(lb_address, tier); err != nil {
+				log.Printf("[WARN] Failed to save tier of load balancer %s: %v", lb_address, err)
+			}
+			log.Printf("[INFO] Load balancer %s moved to tier %d", lb_address, tier)
+			return true
+		}
+	}
+
+	log.Printf("[WARN] Load balancer %s not found", lb_address)
+	return false
+}
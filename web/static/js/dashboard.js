@@ -273,10 +273,120 @@ function updateDashboard(data) {
                 statRow.innerHTML = 
                     '<span>Total: ' + lb.total_connections + '</span>' +
                     '<span class="success">Success: ' + lb.success_count + '</span>' +
-                    '<span class="error">Failures: ' + lb.failure_count + '</span>';
+                    '<span class="error" title="' + lb.failures_refused + ' refused, ' +
+                        lb.failures_unreachable + ' unreachable, ' + lb.failures_timeout + ' timeout, ' +
+                        lb.failures_other + ' other">Failures: ' + lb.failure_count + '</span>';
+            }
+            
+            const latencyElement = lbCard.querySelector('.lb-latency');
+            if (latencyElement) {
+                latencyElement.textContent = lb.latency_samples > 0 ?
+                    lb.connect_latency_ms.toFixed(1) + ' ms avg of ' + lb.latency_samples : 'not measured';
+            }
+            
+            const utilizationElement = lbCard.querySelector('.lb-utilization');
+            if (utilizationElement) {
+                let text = lb.capacity_down_mbps > 0 ?
+                    lb.utilization_down_pct.toFixed(1) + '% of ' + lb.capacity_down_mbps + ' Mbit/s down' : 'capacity not set';
+                if (lb.capacity_up_mbps > 0) {
+                    text += ' · ' + lb.utilization_up_pct.toFixed(1) + '% of ' + lb.capacity_up_mbps + ' Mbit/s up';
+                }
+                utilizationElement.textContent = text;
+            }
+            
+            const spilloverElement = lbCard.querySelector('.lb-spillover');
+            if (spilloverElement) {
+                const limits = [];
+                if (lb.spill_utilization_pct > 0) limits.push(lb.spill_utilization_pct + '%');
+                if (lb.spill_max_connections > 0) limits.push(lb.spill_max_connections + ' connections');
+                spilloverElement.textContent = limits.length > 0 ?
+                    'at ' + limits.join(' or ') + ' · ' + lb.active_count + ' open' : 'off';
+            }
+            
+            const spillStateElement = lbCard.querySelector('.lb-spill-state');
+            if (spillStateElement) {
+                spillStateElement.innerHTML = lb.spilling ? '<span class="text-warning">spilling</span>' : '';
+            }
+            
+            const ratioElement = lbCard.querySelector('.lb-ratio');
+            if (ratioElement) {
+                ratioElement.textContent = 'Ratio: ' + lb.effective_ratio +
+                    (lb.effective_ratio !== lb.default_ratio ? ' (tuned, set ' + lb.default_ratio + ')' : '');
+            }
+            
+            const costElement = lbCard.querySelector('.lb-cost');
+            if (costElement) {
+                costElement.textContent = lb.accumulated_cost.toFixed(2);
             }
         }
     });
+    
+    updateFailoverEvents(data);
+    updateAccessControl(data);
+}
+
+// Update the failover events table
+function updateFailoverEvents(data) {
+    const activeTier = document.getElementById('activeTier');
+    if (activeTier) {
+        activeTier.textContent = (data.active_tier ? 'Active tier: ' + data.active_tier : 'No healthy tier') +
+            (data.spillover_active ? ' · spillover active' : '');
+    }
+    
+    const tbody = document.getElementById('failoverEvents');
+    if (!tbody) return;
+    
+    const events = data.failover_events || [];
+    if (events.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="4" class="text-secondary">No failover events</td></tr>';
+        return;
+    }
+    
+    tbody.innerHTML = events.map(event =>
+        '<tr>' +
+            '<td>' + new Date(event.time).toLocaleString() + '</td>' +
+            '<td><span class="text-' + (event.kind === 'failover' ? 'danger' : 'success') + '">' + event.kind + '</span></td>' +
+            '<td>' + event.from_tier + ' &rarr; ' + event.to_tier + '</td>' +
+            '<td>' + escapeHtml(event.reason) + '</td>' +
+        '</tr>'
+    ).join('');
+}
+
+// Update the ACL entries and denied requests tables
+function updateAccessControl(data) {
+    const entriesBody = document.getElementById('aclEntries');
+    if (entriesBody) {
+        const entries = data.acl_entries || [];
+        entriesBody.innerHTML = entries.length === 0 ?
+            '<tr><td colspan="7" class="text-secondary">No ACL entries, all requests are allowed</td></tr>' :
+            entries.map(entry =>
+                '<tr' + (entry.enabled ? '' : ' class="text-secondary"') + '>' +
+                    '<td>' + entry.priority + '</td>' +
+                    '<td><span class="text-' + (entry.action === 'allow' ? 'success' : 'danger') + '">' + entry.action + '</span></td>' +
+                    '<td>' + escapeHtml(entry.source) + '</td>' +
+                    '<td>' + escapeHtml(entry.destination) + '</td>' +
+                    '<td>' + (entry.port || 'any') + '</td>' +
+                    '<td>' + entry.hits + '</td>' +
+                    '<td>' + escapeHtml(entry.description) + (entry.enabled ? '' : ' (disabled)') + '</td>' +
+                '</tr>'
+            ).join('');
+    }
+    
+    const denialsBody = document.getElementById('aclDenials');
+    if (denialsBody) {
+        const denials = data.acl_denials || [];
+        denialsBody.innerHTML = denials.length === 0 ?
+            '<tr><td colspan="5" class="text-secondary">No denied requests</td></tr>' :
+            denials.map(denial =>
+                '<tr>' +
+                    '<td>' + new Date(denial.time).toLocaleString() + '</td>' +
+                    '<td>' + escapeHtml(denial.protocol) + '</td>' +
+                    '<td>' + escapeHtml(denial.source_ip) + '</td>' +
+                    '<td>' + escapeHtml(denial.destination) + '</td>' +
+                    '<td>' + escapeHtml(denial.entry) + '</td>' +
+                '</tr>'
+            ).join('');
+    }
 }
 
 // Connection filtering functionality
@@ -330,6 +440,13 @@ async function refreshDashboard() {
     }
 }
 
+// Escape text from clients before it is put into HTML, quotes included for attributes
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML.replace(/"/g, '&quot;').replace(/'/g, '&#39;');
+}
+
 // Update connections table with new data
 function updateConnectionsTable(connections) {
     const tbody = document.getElementById('connectionsBody');
@@ -363,13 +480,14 @@ function updateConnectionsTable(connections) {
         row.innerHTML = '' +
             '<td>' +
                 '<div class="d-flex align-items-center">' +
-                    '<span class="font-weight-bold text-primary" onclick="showSourceIPManagement(\'' + conn.source_ip + '\')" style="cursor: pointer;">' + conn.source_ip + '</span>' +
+                    '<span class="font-weight-bold text-primary" onclick="showSourceIPManagement(\'' + escapeHtml(conn.source_ip) + '\')" style="cursor: pointer;">' + escapeHtml(conn.source_ip) + '</span>' +
                     '<span class="text-tertiary">:' + conn.source_port + '</span>' +
+                    (conn.username ? '<span class="text-secondary ml-2"><i class="fas fa-user"></i> ' + escapeHtml(conn.username) + '</span>' : '') +
                 '</div>' +
             '</td>' +
             '<td>' +
                 '<div class="d-flex align-items-center">' +
-                    '<span class="font-weight-bold">' + conn.destination_ip + '</span>' +
+                    '<span class="font-weight-bold">' + escapeHtml(conn.destination_ip) + '</span>' +
                     '<span class="text-tertiary">:' + conn.destination_port + '</span>' +
                 '</div>' +
             '</td>' +
@@ -404,7 +522,7 @@ function updateConnectionsTable(connections) {
                 '</div>' +
             '</td>' +
             '<td>' +
-                '<button class="btn btn-sm btn-primary" onclick="showWeightModal(\'' + conn.source_ip + '\')">' +
+                '<button class="btn btn-sm btn-primary" onclick="showWeightModal(\'' + escapeHtml(conn.source_ip) + '\')">' +
                     '<i class="fas fa-weight-hanging"></i>' +
                     'Set Weight' +
                 '</button>' +
@@ -571,18 +689,23 @@ function showSourceIPManagement(sourceIP) {
     
     showModal('sourceIPModal');
     
-    // Load existing rules for this source IP
-    fetch('/api/stats')
-        .then(response => response.json())
-        .then(data => {
+    // Load existing rules for this source IP; the explain API tells which rule matched on each LB
+    Promise.all([
+        fetch('/api/stats').then(response => response.json()),
+        fetch('/api/explain?source_ip=' + encodeURIComponent(sourceIP)).then(response => response.json())
+    ])
+        .then(([data, explain]) => {
+            const explained = (explain.explanation && explain.explanation.load_balancers) || [];
             let content = '<div class="source-ip-management">';
             content += '<h4>Source IP: <code>' + sourceIP + '</code></h4>';
             content += '<p>Configure custom load balancing rules for this source IP.</p>';
             
             content += '<div class="lb-rules-grid">';
             data.load_balancers.forEach(lb => {
-                const hasRule = lb.source_ip_rules && lb.source_ip_rules[sourceIP];
-                const ratio = hasRule ? lb.source_ip_rules[sourceIP].contention_ratio : lb.default_ratio;
+                const info = explained.find(l => l.address === lb.address);
+                const rule = info && info.rule;
+                const hasRule = rule && rule.source_ip === sourceIP;
+                const ratio = info ? info.effective_ratio : lb.default_ratio;
                 
                 content += '<div class="lb-rule-card">';
                 content += '<h5>LB' + lb.id + ': ' + lb.address + '</h5>';
@@ -593,6 +716,8 @@ function showSourceIPManagement(sourceIP) {
                     content += '<p class="custom-rule">✓ Custom rule active</p>';
                     content += '<button class="btn btn-small btn-danger" onclick="removeSourceIPRule(\'' + 
                                lb.address + '\', \'' + sourceIP + '\')">Remove Rule</button>';
+                } else if (rule) {
+                    content += '<p class="custom-rule">✓ Matched by rule <code>' + rule.source_ip + '</code></p>';
                 } else {
                     content += '<p class="default-rule">Using default ratio</p>';
                 }
@@ -618,6 +743,7 @@ function showAddRuleModal(lbAddress) {
     document.getElementById('sourceIP').value = '';
     document.getElementById('contentionRatio').value = '1';
     document.getElementById('description').value = '';
+    document.getElementById('ruleStrategy').value = '';
     showModal('addRuleModal');
 }
 
@@ -709,7 +835,8 @@ async function addSourceIPRule() {
         lb_address: formData.get('lb_address'),
         source_ip: formData.get('source_ip'),
         contention_ratio: parseInt(formData.get('contention_ratio')),
-        description: formData.get('description')
+        description: formData.get('description'),
+        strategy: formData.get('strategy') || ''
     };
     
     try {
@@ -726,7 +853,7 @@ async function addSourceIPRule() {
             closeModal('addRuleModal');
             refreshDashboard();
         } else {
-            alert('Failed to add rule');
+            alert('Failed to add rule' + (result.error ? ': ' + result.error : ''));
         }
     } catch (error) {
         console.error('Error adding rule:', error);
@@ -759,7 +886,7 @@ async function setWeight() {
             closeModal('weightModal');
             refreshDashboard();
         } else {
-            alert('Failed to set weight');
+            alert('Failed to set weight' + (result.error ? ': ' + result.error : ''));
         }
     } catch (error) {
         console.error('Error setting weight:', error);
@@ -782,13 +909,14 @@ function showRulesModal(lbAddress) {
             
             if (lb.source_ip_rules && Object.keys(lb.source_ip_rules).length > 0) {
                 content += '<table class="data-table">';
-                content += '<thead><tr><th>Source IP</th><th>Ratio</th><th>Description</th><th>Actions</th></tr></thead>';
+                content += '<thead><tr><th>Source IP</th><th>Ratio</th><th>Strategy</th><th>Description</th><th>Actions</th></tr></thead>';
                 content += '<tbody>';
                 
                 Object.entries(lb.source_ip_rules).forEach(([sourceIP, rule]) => {
                     content += '<tr>';
                     content += '<td>' + sourceIP + '</td>';
                     content += '<td>' + rule.contention_ratio + '</td>';
+                    content += '<td>' + (rule.strategy || 'global') + '</td>';
                     content += '<td>' + (rule.description || 'No description') + '</td>';
                     content += '<td>';
                     content += '<button class="btn btn-sm btn-danger" onclick="removeRule(\'' + lbAddress + '\', \'' + sourceIP + '\')">Remove</button>';
@@ -1055,7 +1183,8 @@ document.addEventListener('DOMContentLoaded', function() {
                 lb_address: formData.get('lb_address'),
                 source_ip: formData.get('source_ip'),
                 contention_ratio: parseInt(formData.get('contention_ratio')),
-                description: formData.get('description')
+                description: formData.get('description'),
+                strategy: formData.get('strategy') || ''
             };
             
             try {
@@ -1072,7 +1201,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     closeModal('addRuleModal');
                     refreshDashboard();
                 } else {
-                    alert('Failed to add rule');
+                    alert('Failed to add rule' + (result.error ? ': ' + result.error : ''));
                 }
             } catch (error) {
                 console.error('Error adding rule:', error);
@@ -1109,7 +1238,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     closeModal('weightModal');
                     refreshDashboard();
                 } else {
-                    alert('Failed to set weight');
+                    alert('Failed to set weight' + (result.error ? ': ' + result.error : ''));
                 }
             } catch (error) {
                 console.error('Error setting weight:', error);
@@ -154,7 +154,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     closeModal('addRuleModal');
                     location.reload();
                 } else {
-                    alert('Failed to add rule');
+                    alert('Failed to add rule' + (result.error ? ': ' + result.error : ''));
                 }
             } catch (error) {
                 alert('Error adding rule: ' + error.message);
@@ -80,7 +80,24 @@ class SettingsManager {
             'lport': this.currentSettings.listen_port || 8080,
             'webPort': this.currentSettings.web_port || 0,
             'tunnel': this.currentSettings.tunnel_mode || false,
-            'debug': this.currentSettings.debug_mode || false
+            'debug': this.currentSettings.debug_mode || false,
+            'socksAuth': this.currentSettings.socks_auth || false,
+            'httpProxyPort': this.currentSettings.http_proxy_port || 0,
+            'httpProxyAuth': this.currentSettings.http_proxy_auth || false,
+            'lbStrategy': this.currentSettings.lb_strategy || 'ratio',
+            'latencyTolerance': this.currentSettings.latency_tolerance_ms ?? 20,
+            'affinityTTL': this.currentSettings.affinity_ttl || 0,
+            'affinityScope': this.currentSettings.affinity_scope || 'domain',
+            'spilloverHysteresis': this.currentSettings.spillover_hysteresis_pct ?? 10,
+            'dialMaxAttempts': this.currentSettings.dial_max_attempts || 3,
+            'dialTimeBudget': this.currentSettings.dial_time_budget || 15,
+            'happyEyeballs': this.currentSettings.happy_eyeballs || false,
+            'happyEyeballsDelay': this.currentSettings.happy_eyeballs_delay_ms || 250,
+            'autoTune': this.currentSettings.auto_tune || false,
+            'autoTuneInterval': this.currentSettings.auto_tune_interval || 60,
+            'autoTuneMinRatio': this.currentSettings.auto_tune_min_ratio || 1,
+            'autoTuneMaxRatio': this.currentSettings.auto_tune_max_ratio || 10,
+            'autoTuneSmoothing': this.currentSettings.auto_tune_smoothing || 0.3
         };
 
         Object.entries(elements).forEach(([id, value]) => {
@@ -294,7 +311,7 @@ class SettingsManager {
                     <div class="status-indicator ${lb.enabled ? '' : 'inactive'}"></div>
                     <div class="load-balancer-details">
                         <h4>LB${lb.id}: ${lb.address}</h4>
-                        <p>Interface: ${lb.interface || 'N/A'} • Ratio: ${lb.contention_ratio} • Rules: ${Object.keys(lb.source_ip_rules || {}).length}</p>
+                        <p>Interface: ${lb.interface || 'N/A'} • Ratio: ${lb.contention_ratio}${lb.tuned_ratio ? ` (tuned ${lb.tuned_ratio})` : ''} • Rules: ${Object.keys(lb.source_ip_rules || {}).length} • Capacity: ${lb.capacity_down_mbps || '?'}/${lb.capacity_up_mbps || '?'} Mbit/s • Tier: ${lb.tier} • Cost: ${lb.cost_per_gb ? lb.cost_per_gb + '/GB' : 'unmetered'} (cost tier ${lb.cost_tier}) • Spillover: ${lb.spill_utilization_pct || lb.spill_max_connections ? `${lb.spill_utilization_pct || '-'}% / ${lb.spill_max_connections || '-'} conns` : 'off'}</p>
                     </div>
                 </div>
                 <div class="load-balancer-actions">
@@ -311,6 +328,94 @@ class SettingsManager {
         container.innerHTML = lbHTML;
     }
 
+    // Edit load balancer capacity (Mbit/s, 0 = unknown), failover tier, spillover thresholds and cost
+    async editLoadBalancer(address) {
+        const lb = this.activeLoadBalancers.find(lb => lb.address === address);
+        if (!lb) return;
+
+        const down = prompt(`Downstream capacity of ${address} in Mbit/s (0 = unknown):`, lb.capacity_down_mbps || 0);
+        if (down === null) return;
+        const up = prompt(`Upstream capacity of ${address} in Mbit/s (0 = unknown):`, lb.capacity_up_mbps || 0);
+        if (up === null) return;
+        const tier = prompt(`Failover tier of ${address} (1 = primary, higher tiers are backups):`, lb.tier || 1);
+        if (tier === null) return;
+        const spillPct = prompt(`Spill new connections over when ${address} reaches this utilization in % (0 = off):`, lb.spill_utilization_pct || 0);
+        if (spillPct === null) return;
+        const spillConns = prompt(`Spill new connections over when ${address} has this many open connections (0 = off):`, lb.spill_max_connections || 0);
+        if (spillConns === null) return;
+        const costPerGB = prompt(`Traffic cost of ${address} per GB (0 = unmetered):`, lb.cost_per_gb || 0);
+        if (costPerGB === null) return;
+        const costTier = prompt(`Cost tier of ${address} (1 = cheapest, higher tiers are used when cheaper links are full):`, lb.cost_tier || 1);
+        if (costTier === null) return;
+
+        if (isNaN(down) || isNaN(up) || down < 0 || up < 0) {
+            this.showNotification('Invalid capacity', 'error');
+            return;
+        }
+        if (isNaN(tier) || tier < 1) {
+            this.showNotification('Invalid tier', 'error');
+            return;
+        }
+        if (isNaN(spillPct) || isNaN(spillConns) || spillPct < 0 || spillPct > 100 || spillConns < 0) {
+            this.showNotification('Invalid spillover threshold', 'error');
+            return;
+        }
+        if (isNaN(costPerGB) || isNaN(costTier) || costPerGB < 0 || costTier < 1) {
+            this.showNotification('Invalid cost', 'error');
+            return;
+        }
+
+        try {
+            const response = await fetch('/api/lb/capacity', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    lb_address: address,
+                    capacity_down_mbps: parseInt(down) || 0,
+                    capacity_up_mbps: parseInt(up) || 0
+                })
+            });
+
+            const result = await response.json();
+            const tierResponse = await fetch('/api/lb/tier', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ lb_address: address, tier: parseInt(tier) })
+            });
+            const tierResult = await tierResponse.json();
+            const spillResponse = await fetch('/api/lb/spillover', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    lb_address: address,
+                    spill_utilization_pct: parseInt(spillPct) || 0,
+                    spill_max_connections: parseInt(spillConns) || 0
+                })
+            });
+            const spillResult = await spillResponse.json();
+            const costResponse = await fetch('/api/lb/cost', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    lb_address: address,
+                    cost_per_gb: parseFloat(costPerGB) || 0,
+                    cost_tier: parseInt(costTier) || 1
+                })
+            });
+            const costResult = await costResponse.json();
+
+            if (result.success && tierResult.success && spillResult.success && costResult.success) {
+                this.showNotification(`Load balancer ${address} updated`, 'success');
+                this.loadLoadBalancers();
+            } else {
+                throw new Error(result.error || tierResult.error || spillResult.error || costResult.error || 'Failed to update load balancer');
+            }
+        } catch (error) {
+            console.error('Failed to update load balancer:', error);
+            this.showNotification('Failed to update load balancer', 'error');
+        }
+    }
+
     // Remove load balancer
     async removeLoadBalancer(address) {
         if (!confirm(`Are you sure you want to remove load balancer ${address}?`)) {
@@ -458,6 +563,23 @@ class SettingsManager {
             web_port: parseInt(document.getElementById('webPort')?.value) || 0,
             tunnel_mode: document.getElementById('tunnel')?.checked || false,
             debug_mode: document.getElementById('debug')?.checked || false,
+            socks_auth: document.getElementById('socksAuth')?.checked || false,
+            http_proxy_port: parseInt(document.getElementById('httpProxyPort')?.value) || 0,
+            http_proxy_auth: document.getElementById('httpProxyAuth')?.checked || false,
+            lb_strategy: document.getElementById('lbStrategy')?.value || 'ratio',
+            latency_tolerance_ms: parseInt(document.getElementById('latencyTolerance')?.value) || 0,
+            affinity_ttl: parseInt(document.getElementById('affinityTTL')?.value) || 0,
+            affinity_scope: document.getElementById('affinityScope')?.value || 'domain',
+            spillover_hysteresis_pct: parseInt(document.getElementById('spilloverHysteresis')?.value) || 0,
+            dial_max_attempts: parseInt(document.getElementById('dialMaxAttempts')?.value) || 3,
+            dial_time_budget: parseInt(document.getElementById('dialTimeBudget')?.value) || 15,
+            happy_eyeballs: document.getElementById('happyEyeballs')?.checked || false,
+            happy_eyeballs_delay_ms: parseInt(document.getElementById('happyEyeballsDelay')?.value) || 250,
+            auto_tune: document.getElementById('autoTune')?.checked || false,
+            auto_tune_interval: parseInt(document.getElementById('autoTuneInterval')?.value) || 60,
+            auto_tune_min_ratio: parseInt(document.getElementById('autoTuneMinRatio')?.value) || 1,
+            auto_tune_max_ratio: parseInt(document.getElementById('autoTuneMaxRatio')?.value) || 10,
+            auto_tune_smoothing: parseFloat(document.getElementById('autoTuneSmoothing')?.value) || 0.3,
             
             // Gateway settings (flattened to match API expectations)
             gateway_mode: document.getElementById('gatewayEnabled')?.checked || false,
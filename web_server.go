@@ -39,6 +39,12 @@ type DashboardData struct {
 	ConnectionHistory   []active_connection   `json:"connection_history"`
 	TrafficStats        GlobalTrafficStats    `json:"traffic_stats"`
 	GatewayConfig       GatewayWebInfo        `json:"gateway_config"`
+	ActiveTier          int                   `json:"active_tier"`
+	FailoverEvents      []tier_event          `json:"failover_events"`
+	ACLEntries          []DBProxyACL          `json:"acl_entries"`
+	ACLDenials          []acl_denial          `json:"acl_denials"`
+	SpilloverActive     bool                  `json:"spillover_active"`        // any load balancer is spilling over
+	TotalCost           float64               `json:"total_cost"`              // accumulated cost of metered links
 }
 
 type LoadBalancerWebInfo struct {
@@ -50,6 +56,27 @@ type LoadBalancerWebInfo struct {
 	TotalConnections int                        `json:"total_connections"`
 	SuccessCount     int                        `json:"success_count"`
 	FailureCount     int                        `json:"failure_count"`
+	FailuresRefused  int                        `json:"failures_refused"`
+	FailuresUnreachable int                     `json:"failures_unreachable"`
+	FailuresTimeout  int                        `json:"failures_timeout"`
+	FailuresOther    int                        `json:"failures_other"`
+	ConnectLatencyMs float64                    `json:"connect_latency_ms"`      // moving average of connect times
+	LatencySamples   int                        `json:"latency_samples"`
+	CapacityDownMbps int                        `json:"capacity_down_mbps"`      // 0 = not configured
+	CapacityUpMbps   int                        `json:"capacity_up_mbps"`
+	UtilizationDownPct float64                  `json:"utilization_down_pct"`    // current rate against capacity
+	UtilizationUpPct float64                    `json:"utilization_up_pct"`
+	Tier             int                        `json:"tier"`
+	Healthy          bool                       `json:"healthy"`
+	ConsecutiveFailures int                     `json:"consecutive_failures"`
+	SpillUtilizationPct int                     `json:"spill_utilization_pct"`   // 0 = no utilization threshold
+	SpillMaxConnections int                     `json:"spill_max_connections"`   // 0 = no connection threshold
+	Spilling         bool                       `json:"spilling"`
+	ActiveCount      int64                      `json:"active_count"`
+	CostPerGB        float64                    `json:"cost_per_gb"`             // 0 = unmetered
+	CostTier         int                        `json:"cost_tier"`
+	AccumulatedCost  float64                    `json:"accumulated_cost"`
+	EffectiveRatio   int                        `json:"effective_ratio"`         // auto-tuned ratio while auto-tune is on
 	SuccessRate      float64                    `json:"success_rate"`
 	SourceIPRules    map[string]source_ip_rule  `json:"source_ip_rules"`
 	ActiveSources    map[string]int             `json:"active_sources"`
@@ -66,6 +93,8 @@ type SourceIPInfo struct {
 	ActiveConnections int   `json:"active_connections"`
 	AssignedLB       string `json:"assigned_lb"`
 	EffectiveRatio   int    `json:"effective_ratio"`
+	MatchedRule      string `json:"matched_rule,omitempty"` // source IP rule of the assigned LB that applies (IP, CIDR or range)
+	Cost             float64 `json:"cost"`            // accumulated cost over metered links
 	// Enhanced traffic statistics
 	BytesInTotal     int64  `json:"bytes_in_total"`
 	BytesOutTotal    int64  `json:"bytes_out_total"`
@@ -138,6 +167,23 @@ type GlobalSettings struct {
 	TunnelMode      bool
 	DebugMode       bool
 	QuietMode       bool
+	SocksAuth       bool
+	HTTPProxyPort   int
+	HTTPProxyAuth   bool
+	LBStrategy      string
+	LatencyToleranceMs int
+	AffinityTTL     int
+	AffinityScope   string
+	SpilloverHysteresisPct int
+	AutoTune        bool
+	AutoTuneInterval int
+	AutoTuneMinRatio int
+	AutoTuneMaxRatio int
+	AutoTuneSmoothing float64
+	DialMaxAttempts int
+	DialTimeBudget  int
+	HappyEyeballs   bool
+	HappyEyeballsDelayMs int
 	GatewayMode     bool
 	GatewayIP       string
 	SubnetCIDR      string
@@ -270,7 +316,17 @@ func (ws *WebServer) Start() error {
 	http.HandleFunc("/api/stats", ws.handleAPIStats)
 	http.HandleFunc("/api/config", ws.handleAPIConfig)
 	http.HandleFunc("/api/rules", ws.handleAPIRules)
+	http.HandleFunc("/api/rules/destination", ws.handleAPIDestinationRules)
+	http.HandleFunc("/api/rules/client", ws.handleAPIClientPolicies)
+	http.HandleFunc("/api/acl", ws.handleAPIACL)
 	http.HandleFunc("/api/lb/toggle", ws.handleAPIToggleLB)
+	http.HandleFunc("/api/lb/capacity", ws.handleAPILBCapacity)
+	http.HandleFunc("/api/lb/tier", ws.handleAPILBTier)
+	http.HandleFunc("/api/lb/spillover", ws.handleAPILBSpillover)
+	http.HandleFunc("/api/lb/cost", ws.handleAPILBCost)
+	http.HandleFunc("/api/costs", ws.handleAPICosts)
+	http.HandleFunc("/api/autotune", ws.handleAPIAutoTune)
+	http.HandleFunc("/api/failover", ws.handleAPIFailover)
 	http.HandleFunc("/api/connections", ws.handleAPIConnections)
 	http.HandleFunc("/api/traffic", ws.handleAPITraffic)
 	http.HandleFunc("/api/traffic/chart", ws.handleAPITrafficChart)
@@ -286,6 +342,11 @@ func (ws *WebServer) Start() error {
 	http.HandleFunc("/api/lb/remove", ws.handleAPIRemoveLB)
 	http.HandleFunc("/api/resolve-hostname", ws.handleAPIResolveHostname)
 	http.HandleFunc("/api/device-info", ws.handleAPIDeviceInfo)
+	http.HandleFunc("/api/users", ws.handleAPIUsers)
+	http.HandleFunc("/api/listeners", ws.handleAPIListeners)
+	http.HandleFunc("/api/affinity", ws.handleAPIAffinity)
+	http.HandleFunc("/api/race", ws.handleAPIRace)
+	http.HandleFunc("/api/explain", ws.handleAPIExplain)
 	// Removed - not needed with SQLite storage
 	// http.HandleFunc("/api/export", ws.handleAPIExport)
 	// http.HandleFunc("/api/import", ws.handleAPIImport)
@@ -527,6 +588,7 @@ func (ws *WebServer) handleAPIRules(w http.ResponseWriter, r *http.Request) {
 				SourceIP        string `json:"source_ip"`
 				ContentionRatio int    `json:"contention_ratio"`
 				Description     string `json:"description"`
+				Strategy        string `json:"strategy"`
 			}
 			
 			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
@@ -534,7 +596,25 @@ func (ws *WebServer) handleAPIRules(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			
-			success := add_source_ip_rule(rule.LBAddress, rule.SourceIP, rule.ContentionRatio, rule.Description)
+			if !valid_source_pattern(rule.SourceIP) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Invalid source " + rule.SourceIP + ": use an IP, a CIDR block (192.168.10.0/24) or a range (192.168.10.50-192.168.10.99)",
+				})
+				return
+			}
+			rule.SourceIP = normalize_source_pattern(rule.SourceIP)
+			
+			// An empty strategy follows the global setting
+			if rule.Strategy != "" && !valid_lb_strategy(rule.Strategy) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Unknown strategy " + rule.Strategy,
+				})
+				return
+			}
+			
+			success := add_source_ip_rule(rule.LBAddress, rule.SourceIP, rule.ContentionRatio, rule.Description, rule.Strategy)
 			json.NewEncoder(w).Encode(map[string]bool{"success": success})
 			
 		case "DELETE":
@@ -547,7 +627,7 @@ func (ws *WebServer) handleAPIRules(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			
-			success := remove_source_ip_rule(lbAddress, sourceIP)
+			success := remove_source_ip_rule(lbAddress, normalize_source_pattern(sourceIP))
 			json.NewEncoder(w).Encode(map[string]bool{"success": success})
 			
 		default:
@@ -583,6 +663,710 @@ func (ws *WebServer) handleAPIToggleLB(w http.ResponseWriter, r *http.Request) {
 	})(w, r)
 }
 
+/*
+Handle API load balancer capacity endpoint
+*/
+func (ws *WebServer) handleAPILBCapacity(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		var req struct {
+			LBAddress        string `json:"lb_address"`
+			CapacityDownMbps int    `json:"capacity_down_mbps"`
+			CapacityUpMbps   int    `json:"capacity_up_mbps"`
+		}
+		
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		if req.CapacityDownMbps < 0 || req.CapacityUpMbps < 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Capacity cannot be negative",
+			})
+			return
+		}
+		
+		success := set_load_balancer_capacity(req.LBAddress, req.CapacityDownMbps, req.CapacityUpMbps)
+		json.NewEncoder(w).Encode(map[string]bool{"success": success})
+	})(w, r)
+}
+
+/*
+Handle API load balancer tier endpoint
+*/
+func (ws *WebServer) handleAPILBTier(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		var req struct {
+			LBAddress string `json:"lb_address"`
+			Tier      int    `json:"tier"`
+		}
+		
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		if req.Tier < 1 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Tier must be 1 or higher",
+			})
+			return
+		}
+		
+		success := set_load_balancer_tier(req.LBAddress, req.Tier)
+		json.NewEncoder(w).Encode(map[string]bool{"success": success})
+	})(w, r)
+}
+
+/*
+Handle API load balancer spillover endpoint
+*/
+func (ws *WebServer) handleAPILBSpillover(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		var req struct {
+			LBAddress           string `json:"lb_address"`
+			SpillUtilizationPct int    `json:"spill_utilization_pct"`
+			SpillMaxConnections int    `json:"spill_max_connections"`
+		}
+		
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		if req.SpillUtilizationPct < 0 || req.SpillUtilizationPct > 100 || req.SpillMaxConnections < 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Utilization threshold must be between 0 and 100 and the connection threshold cannot be negative",
+			})
+			return
+		}
+		
+		success := set_load_balancer_spillover(req.LBAddress, req.SpillUtilizationPct, req.SpillMaxConnections)
+		json.NewEncoder(w).Encode(map[string]bool{"success": success})
+	})(w, r)
+}
+
+/*
+Handle API load balancer cost endpoint
+*/
+func (ws *WebServer) handleAPILBCost(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		var req struct {
+			LBAddress string  `json:"lb_address"`
+			CostPerGB float64 `json:"cost_per_gb"`
+			CostTier  int     `json:"cost_tier"`
+		}
+		
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		if req.CostTier <= 0 {
+			req.CostTier = 1
+		}
+		if req.CostPerGB < 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Cost per GB cannot be negative",
+			})
+			return
+		}
+		
+		success := set_load_balancer_cost(req.LBAddress, req.CostPerGB, req.CostTier)
+		json.NewEncoder(w).Encode(map[string]bool{"success": success})
+	})(w, r)
+}
+
+/*
+Handle API costs endpoint: accumulated costs per load balancer and client (GET)
+or a reset for a new billing period (DELETE)
+*/
+func (ws *WebServer) handleAPICosts(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		switch r.Method {
+		case "GET":
+			lbs, clients, total := get_cost_report()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"load_balancers": lbs,
+				"clients":        clients,
+				"total_cost":     total,
+			})
+		case "DELETE":
+			reset_costs()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"message": "Accumulated costs reset",
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})(w, r)
+}
+
+/*
+Handle API auto-tune endpoint with the auto-tune settings, current ratios and
+the most recent ratio adjustments
+*/
+func (ws *WebServer) handleAPIAutoTune(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		adjustments, err := loadRatioAdjustments(max_ratio_adjustments)
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("Failed to load ratio adjustments: %v", err),
+			})
+			return
+		}
+		
+		mutex.Lock()
+		ratios := make([]map[string]interface{}, len(lb_list))
+		for i := range lb_list {
+			ratios[i] = map[string]interface{}{
+				"address":          lb_list[i].address,
+				"contention_ratio": lb_list[i].contention_ratio,
+				"tuned_ratio":      lb_list[i].tuned_ratio,
+				"effective_ratio":  get_base_contention_ratio(&lb_list[i]),
+			}
+		}
+		mutex.Unlock()
+		
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":        currentSettings.AutoTune,
+			"interval":       currentSettings.AutoTuneInterval,
+			"min_ratio":      currentSettings.AutoTuneMinRatio,
+			"max_ratio":      currentSettings.AutoTuneMaxRatio,
+			"smoothing":      currentSettings.AutoTuneSmoothing,
+			"load_balancers": ratios,
+			"adjustments":    adjustments,
+		})
+	})(w, r)
+}
+
+/*
+Handle API failover endpoint with the active tier and recent failover events
+*/
+func (ws *WebServer) handleAPIFailover(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		activeTier, events := get_tier_status()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"active_tier": activeTier,
+			"events":      events,
+		})
+	})(w, r)
+}
+
+/*
+Handle API affinity endpoint for viewing and clearing sticky sessions
+*/
+func (ws *WebServer) handleAPIAffinity(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		switch r.Method {
+		case "GET":
+			entries := get_affinities()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"affinities":  entries,
+				"total_count": len(entries),
+				"ttl":         currentSettings.AffinityTTL,
+				"scope":       currentSettings.AffinityScope,
+			})
+			
+		case "DELETE":
+			// Without parameters every sticky session is cleared
+			removed := clear_affinities(r.URL.Query().Get("source_ip"), r.URL.Query().Get("destination"))
+			log.Printf("[INFO] Cleared %d sticky sessions via WebUI", removed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"cleared": removed,
+			})
+			
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})(w, r)
+}
+
+/*
+Handle API destination rules endpoint for routing by destination
+*/
+func (ws *WebServer) handleAPIDestinationRules(w http.ResponseWriter, r *http.Request) {
+	if !ws.isAuthenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rules := get_destination_rules()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rules":       rules,
+			"total_count": len(rules),
+		})
+
+	case "POST":
+		// Create destination rule (no id) or update an existing one
+		var request struct {
+			ID          int    `json:"id"`
+			Priority    *int   `json:"priority"`
+			Destination string `json:"destination"`
+			Port        int    `json:"port"`
+			Action      string `json:"action"`
+			LBAddress   string `json:"lb_address"`
+			Enabled     *bool  `json:"enabled"`
+			Description string `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if request.Action == "" {
+			request.Action = "prefer"
+		}
+		if !valid_destination_pattern(request.Destination) || request.Port < 0 || request.Port > 65535 ||
+			!valid_destination_rule_action(request.Action) {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("Invalid destination rule: destination must be *, *.domain, a CIDR block, an IP or a host name, port 0-65535, action one of %s", strings.Join(destination_rule_actions, ", ")),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		lbExists := false
+		mutex.Lock()
+		for i := range lb_list {
+			if lb_list[i].address == request.LBAddress {
+				lbExists = true
+			}
+		}
+		mutex.Unlock()
+		if !lbExists {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Load balancer not found: " + request.LBAddress,
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		rule := DBDestinationRule{
+			ID:          request.ID,
+			Destination: strings.ToLower(strings.TrimSpace(request.Destination)),
+			Port:        request.Port,
+			Action:      request.Action,
+			LBAddress:   request.LBAddress,
+			Enabled:     true,
+			Description: request.Description,
+		}
+		if request.Enabled != nil {
+			rule.Enabled = *request.Enabled
+		}
+		if request.Priority != nil {
+			rule.Priority = *request.Priority
+		} else {
+			// Without a priority a new rule goes after all existing ones
+			for _, existing := range get_destination_rules() {
+				if existing.Priority >= rule.Priority {
+					rule.Priority = existing.Priority + 10
+				}
+			}
+		}
+
+		id, err := saveDestinationRule(rule)
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_destination_rules()
+
+		rule.ID = id
+		log.Printf("[INFO] %s with priority %d saved via WebUI", describe_destination_rule(rule), rule.Priority)
+		response := map[string]interface{}{
+			"success":  true,
+			"id":       id,
+			"priority": rule.Priority,
+			"message":  "Destination rule saved",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Missing parameters", http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteDestinationRule(id); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_destination_rules()
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "Destination rule removed successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+Handle API client policies endpoint for the load balancers clients may use
+*/
+func (ws *WebServer) handleAPIClientPolicies(w http.ResponseWriter, r *http.Request) {
+	if !ws.isAuthenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		policies := get_client_policies()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"policies":    policies,
+			"total_count": len(policies),
+		})
+
+	case "POST":
+		// Create client policy (no id) or update an existing one
+		var request struct {
+			ID          int      `json:"id"`
+			Source      string   `json:"source"`
+			Mode        string   `json:"mode"`
+			LBAddresses []string `json:"lb_addresses"`
+			Enabled     *bool    `json:"enabled"`
+			Description string   `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if !valid_source_pattern(request.Source) || !valid_client_policy_mode(request.Mode) || len(request.LBAddresses) == 0 {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Invalid client policy: source must be an IP, a CIDR block or an IP range, mode allow or deny, and at least one load balancer is required",
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		for _, address := range request.LBAddresses {
+			lbExists := false
+			mutex.Lock()
+			for i := range lb_list {
+				if lb_list[i].address == address {
+					lbExists = true
+				}
+			}
+			mutex.Unlock()
+			if !lbExists {
+				response := map[string]interface{}{
+					"success": false,
+					"error":   "Load balancer not found: " + address,
+				}
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+		}
+
+		policy := DBClientPolicy{
+			ID:          request.ID,
+			Source:      normalize_source_pattern(request.Source),
+			Mode:        request.Mode,
+			LBAddresses: request.LBAddresses,
+			Enabled:     true,
+			Description: request.Description,
+		}
+		if request.Enabled != nil {
+			policy.Enabled = *request.Enabled
+		}
+
+		id, err := saveClientPolicy(policy)
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_client_policies()
+
+		policy.ID = id
+		log.Printf("[INFO] %s saved via WebUI", describe_client_policy(policy))
+		response := map[string]interface{}{
+			"success": true,
+			"id":      id,
+			"message": "Client policy saved",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Missing parameters", http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteClientPolicy(id); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_client_policies()
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "Client policy removed successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+Handle API ACL endpoint for access control of proxy requests
+*/
+func (ws *WebServer) handleAPIACL(w http.ResponseWriter, r *http.Request) {
+	if !ws.isAuthenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		entries := get_proxy_acls()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries":     entries,
+			"denials":     get_acl_denials(),
+			"total_count": len(entries),
+		})
+
+	case "POST":
+		// Create ACL entry (no id) or update an existing one
+		var request struct {
+			ID          int    `json:"id"`
+			Priority    *int   `json:"priority"`
+			Action      string `json:"action"`
+			Source      string `json:"source"`
+			Destination string `json:"destination"`
+			Port        int    `json:"port"`
+			Enabled     *bool  `json:"enabled"`
+			Description string `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if request.Source == "" {
+			request.Source = "*"
+		}
+		if request.Destination == "" {
+			request.Destination = "*"
+		}
+		if !valid_proxy_acl_action(request.Action) || (request.Source != "*" && !valid_source_pattern(request.Source)) ||
+			!valid_destination_pattern(request.Destination) || request.Port < 0 || request.Port > 65535 {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Invalid ACL entry: action must be allow or deny, source *, an IP, a CIDR block or an IP range, destination *, *.domain, a CIDR block, an IP or a host name, port 0-65535",
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		entry := DBProxyACL{
+			ID:          request.ID,
+			Action:      request.Action,
+			Source:      request.Source,
+			Destination: strings.ToLower(strings.TrimSpace(request.Destination)),
+			Port:        request.Port,
+			Enabled:     true,
+			Description: request.Description,
+		}
+		if entry.Source != "*" {
+			entry.Source = normalize_source_pattern(entry.Source)
+		}
+		if request.Enabled != nil {
+			entry.Enabled = *request.Enabled
+		}
+		if request.Priority != nil {
+			entry.Priority = *request.Priority
+		} else {
+			// Without a priority a new entry goes after all existing ones
+			for _, existing := range get_proxy_acls() {
+				if existing.Priority >= entry.Priority {
+					entry.Priority = existing.Priority + 10
+				}
+			}
+		}
+
+		id, err := saveProxyACL(entry)
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_proxy_acls()
+
+		entry.ID = id
+		log.Printf("[INFO] %s with priority %d saved via WebUI", describe_proxy_acl(entry), entry.Priority)
+		response := map[string]interface{}{
+			"success":  true,
+			"id":       id,
+			"priority": entry.Priority,
+			"message":  "ACL entry saved",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Missing parameters", http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteProxyACL(id); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_proxy_acls()
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "ACL entry removed successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+Handle API explain endpoint: which load balancer the next connection of a
+source IP would use and why, without consuming a slot
+*/
+func (ws *WebServer) handleAPIExplain(w http.ResponseWriter, r *http.Request) {
+	ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		sourceIP := r.URL.Query().Get("source_ip")
+		if net.ParseIP(sourceIP) == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "source_ip must be an IP address",
+			})
+			return
+		}
+		
+		// The destination (host:port) only matters for sticky sessions
+		destination := r.URL.Query().Get("destination")
+		if destination != "" {
+			if _, _, err := net.SplitHostPort(destination); err != nil {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "destination must be host:port",
+				})
+				return
+			}
+		}
+		
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"explanation": explain_load_balancer_selection(sourceIP, destination),
+		})
+	})(w, r)
+}
+
 /*
 Handle API connections endpoint for real-time connection monitoring
 */
@@ -714,7 +1498,26 @@ func (ws *WebServer) handleAPIConnectionWeight(w http.ResponseWriter, r *http.Re
 				return
 			}
 			
-			success := add_source_ip_rule(req.LBAddress, req.SourceIP, req.ContentionRatio, req.Description)
+			if !valid_source_pattern(req.SourceIP) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Invalid source " + req.SourceIP + ": use an IP, a CIDR block (192.168.10.0/24) or a range (192.168.10.50-192.168.10.99)",
+				})
+				return
+			}
+			req.SourceIP = normalize_source_pattern(req.SourceIP)
+			
+			// Changing the weight keeps the strategy of an existing rule
+			strategy := ""
+			mutex.Lock()
+			for i := range lb_list {
+				if lb_list[i].address == req.LBAddress {
+					strategy = lb_list[i].source_ip_rules[req.SourceIP].Strategy
+				}
+			}
+			mutex.Unlock()
+			
+			success := add_source_ip_rule(req.LBAddress, req.SourceIP, req.ContentionRatio, req.Description, strategy)
 			response := map[string]interface{}{
 				"success": success,
 				"message": "Connection weight updated successfully",
@@ -782,6 +1585,9 @@ Get dashboard data
 func (ws *WebServer) getDashboardData() DashboardData {
 	var data DashboardData
 	
+	// Snapshot of the active connections, taken before locking the load balancers
+	connections := get_active_connections("", "", 0)
+	
 	// Collect load balancer data with minimal locking
 	func() {
 		mutex.Lock()
@@ -812,6 +1618,8 @@ func (ws *WebServer) getDashboardData() DashboardData {
 				activeSourcesCopy[k] = v
 			}
 			
+			utilizationDown, utilizationUp := get_link_utilization(&lb_list[i])
+			
 			data.LoadBalancers[i] = LoadBalancerWebInfo{
 				ID:               i + 1,
 				Address:          lb.address,
@@ -821,6 +1629,27 @@ func (ws *WebServer) getDashboardData() DashboardData {
 				TotalConnections: lb.total_connections,
 				SuccessCount:     lb.success_count,
 				FailureCount:     lb.failure_count,
+				FailuresRefused:  lb.failures_refused,
+				FailuresUnreachable: lb.failures_unreachable,
+				FailuresTimeout:  lb.failures_timeout,
+				FailuresOther:    lb.failures_other,
+				ConnectLatencyMs: lb.connect_latency,
+				LatencySamples:   lb.latency_samples,
+				CapacityDownMbps: lb.capacity_down_mbps,
+				CapacityUpMbps:   lb.capacity_up_mbps,
+				UtilizationDownPct: utilizationDown,
+				UtilizationUpPct: utilizationUp,
+				Tier:             lb.tier,
+				Healthy:          load_balancer_healthy(&lb_list[i]),
+				ConsecutiveFailures: lb.consecutive_failures,
+				SpillUtilizationPct: lb.spill_utilization_pct,
+				SpillMaxConnections: lb.spill_max_connections,
+				Spilling:         lb.spilling,
+				ActiveCount:      atomic.LoadInt64(&lb_list[i].active_count),
+				CostPerGB:        lb.cost_per_gb,
+				CostTier:         lb.cost_tier,
+				AccumulatedCost:  lb.accumulated_cost,
+				EffectiveRatio:   get_base_contention_ratio(&lb_list[i]),
 				SuccessRate:      successRate,
 				SourceIPRules:    sourceIPRulesCopy,
 				ActiveSources:    activeSourcesCopy,
@@ -830,38 +1659,58 @@ func (ws *WebServer) getDashboardData() DashboardData {
 				BytesOutPerSecond: lb.bytes_out_per_second,
 			}
 			
+			if lb.spilling {
+				data.SpilloverActive = true
+			}
+			data.TotalCost += lb.accumulated_cost
+			
 			totalConnections += lb.total_connections
 			totalSuccess += lb.success_count
 			totalFailures += lb.failure_count
 			
-			// Collect source IP information
-			for sourceIP, count := range activeSourcesCopy {
-				// Get client traffic stats
-				clientStats := getClientTrafficStats(sourceIP)
-				
-				if sourceInfo, exists := sourceMap[sourceIP]; exists {
-					sourceInfo.ActiveConnections += count
-					sourceInfo.TotalConnections += lb.total_connections
-					// Update with real traffic data
-					sourceInfo.BytesInTotal += clientStats.BytesInTotal
-					sourceInfo.BytesOutTotal += clientStats.BytesOutTotal
-					sourceInfo.BytesInPerSecond = clientStats.BytesInPerSecond
-					sourceInfo.BytesOutPerSecond = clientStats.BytesOutPerSecond
-				} else {
-					effectiveRatio := get_effective_contention_ratio(&lb, sourceIP)
-					sourceMap[sourceIP] = &SourceIPInfo{
-						SourceIP:          sourceIP,
-						TotalConnections:  lb.total_connections,
-						ActiveConnections: count,
-						AssignedLB:        lb.address,
-						EffectiveRatio:    effectiveRatio,
-						BytesInTotal:     clientStats.BytesInTotal,
-						BytesOutTotal:    clientStats.BytesOutTotal,
-						BytesInPerSecond:  clientStats.BytesInPerSecond,
-						BytesOutPerSecond: clientStats.BytesOutPerSecond,
-					}
+		}
+		
+		// Collect source IP information from active connections, whatever strategy placed them.
+		// A source is shown on the load balancer carrying most of its connections.
+		sourceLBCounts := make(map[string]map[int]int)
+		for _, conn := range connections {
+			lbIndex := load_balancer_index(conn.LoadBalancer)
+			if lbIndex < 0 {
+				continue
+			}
+			if sourceLBCounts[conn.SourceIP] == nil {
+				sourceLBCounts[conn.SourceIP] = make(map[int]int)
+			}
+			sourceLBCounts[conn.SourceIP][lbIndex]++
+		}
+		for sourceIP, lbCounts := range sourceLBCounts {
+			assigned, active := -1, 0
+			for i, count := range lbCounts {
+				active += count
+				if assigned < 0 || count > lbCounts[assigned] || (count == lbCounts[assigned] && i < assigned) {
+					assigned = i
 				}
 			}
+			lb := &lb_list[assigned]
+			clientStats := getClientTrafficStats(sourceIP)
+			sourceInfo := &SourceIPInfo{
+				SourceIP:          sourceIP,
+				TotalConnections:  lb.total_connections,
+				ActiveConnections: active,
+				AssignedLB:        lb.address,
+				EffectiveRatio:    get_effective_contention_ratio(lb, sourceIP),
+				BytesInTotal:      clientStats.BytesInTotal,
+				BytesOutTotal:     clientStats.BytesOutTotal,
+				BytesInPerSecond:  clientStats.BytesInPerSecond,
+				BytesOutPerSecond: clientStats.BytesOutPerSecond,
+			}
+			if client, exists := client_costs[sourceIP]; exists {
+				sourceInfo.Cost = client.Cost
+			}
+			if rule, exists := get_source_ip_rule(lb, sourceIP); exists {
+				sourceInfo.MatchedRule = rule.SourceIP
+			}
+			sourceMap[sourceIP] = sourceInfo
 		}
 		
 		data.TotalConnections = totalConnections
@@ -935,6 +1784,13 @@ func (ws *WebServer) getDashboardData() DashboardData {
 		data.TrafficStats.ConnectionsPerSecond = int64(float64(data.TotalConnections) / uptime.Seconds())
 	}
 	
+	// Failover state
+	data.ActiveTier, data.FailoverEvents = get_tier_status()
+
+	// Access control entries and recent denials
+	data.ACLEntries = get_proxy_acls()
+	data.ACLDenials = get_acl_denials()
+	
 	return data
 }
 
@@ -954,6 +1810,14 @@ func (ws *WebServer) getLoadBalancersConfig() []map[string]interface{} {
 			"contention_ratio": lb.contention_ratio,
 			"enabled":          lb.enabled,
 			"source_ip_rules":  lb.source_ip_rules,
+			"capacity_down_mbps": lb.capacity_down_mbps,
+			"capacity_up_mbps":   lb.capacity_up_mbps,
+			"tier":             lb.tier,
+			"spill_utilization_pct": lb.spill_utilization_pct,
+			"spill_max_connections": lb.spill_max_connections,
+			"cost_per_gb":      lb.cost_per_gb,
+			"cost_tier":        lb.cost_tier,
+			"tuned_ratio":      lb.tuned_ratio,
 		}
 	}
 	return config
@@ -1356,6 +2220,24 @@ func (ws *WebServer) handleSettings(w http.ResponseWriter, r *http.Request) {
 			"ConfigFile":  currentSettings.ConfigFile,
 			"TunnelMode":  currentSettings.TunnelMode,
 			"DebugMode":   currentSettings.DebugMode,
+			"SocksAuth":   currentSettings.SocksAuth,
+			"HTTPProxyPort": currentSettings.HTTPProxyPort,
+			"HTTPProxyAuth": currentSettings.HTTPProxyAuth,
+			"LBStrategy":  currentSettings.LBStrategy,
+			"LBStrategies": lb_strategy_names,
+			"LatencyToleranceMs": currentSettings.LatencyToleranceMs,
+			"AffinityTTL": currentSettings.AffinityTTL,
+			"AffinityScope": currentSettings.AffinityScope,
+			"SpilloverHysteresisPct": currentSettings.SpilloverHysteresisPct,
+			"AutoTune":    currentSettings.AutoTune,
+			"AutoTuneInterval": currentSettings.AutoTuneInterval,
+			"AutoTuneMinRatio": currentSettings.AutoTuneMinRatio,
+			"AutoTuneMaxRatio": currentSettings.AutoTuneMaxRatio,
+			"AutoTuneSmoothing": currentSettings.AutoTuneSmoothing,
+			"DialMaxAttempts": currentSettings.DialMaxAttempts,
+			"DialTimeBudget": currentSettings.DialTimeBudget,
+			"HappyEyeballs": currentSettings.HappyEyeballs,
+			"HappyEyeballsDelayMs": currentSettings.HappyEyeballsDelayMs,
 		},
 		GatewayConfig: GatewayWebInfo{
 			Enabled:         gateway_cfg.enabled,
@@ -1416,6 +2298,23 @@ func (ws *WebServer) handleAPISettings(w http.ResponseWriter, r *http.Request) {
 			"tunnel_mode":      currentSettings.TunnelMode,
 			"debug_mode":       currentSettings.DebugMode,
 			"quiet_mode":       currentSettings.QuietMode,
+			"socks_auth":       currentSettings.SocksAuth,
+			"http_proxy_port":  currentSettings.HTTPProxyPort,
+			"http_proxy_auth":  currentSettings.HTTPProxyAuth,
+			"lb_strategy":      currentSettings.LBStrategy,
+			"latency_tolerance_ms": currentSettings.LatencyToleranceMs,
+			"affinity_ttl":     currentSettings.AffinityTTL,
+			"affinity_scope":   currentSettings.AffinityScope,
+			"spillover_hysteresis_pct": currentSettings.SpilloverHysteresisPct,
+			"auto_tune":        currentSettings.AutoTune,
+			"auto_tune_interval": currentSettings.AutoTuneInterval,
+			"auto_tune_min_ratio": currentSettings.AutoTuneMinRatio,
+			"auto_tune_max_ratio": currentSettings.AutoTuneMaxRatio,
+			"auto_tune_smoothing": currentSettings.AutoTuneSmoothing,
+			"dial_max_attempts": currentSettings.DialMaxAttempts,
+			"dial_time_budget": currentSettings.DialTimeBudget,
+			"happy_eyeballs":   currentSettings.HappyEyeballs,
+			"happy_eyeballs_delay_ms": currentSettings.HappyEyeballsDelayMs,
 			"gateway_mode":     currentSettings.GatewayMode,
 			"gateway_ip":       currentSettings.GatewayIP,
 			"subnet_cidr":      currentSettings.SubnetCIDR,
@@ -1444,6 +2343,157 @@ func (ws *WebServer) handleAPISettings(w http.ResponseWriter, r *http.Request) {
 			updated = append(updated, "debug_mode")
 		}
 
+		// SOCKS authentication is checked per handshake and applies immediately
+		if socksAuth, ok := newSettings["socks_auth"].(bool); ok {
+			currentSettings.SocksAuth = socksAuth
+			updated = append(updated, "socks_auth")
+		}
+
+		// HTTP proxy authentication is checked per request and applies immediately
+		if httpProxyAuth, ok := newSettings["http_proxy_auth"].(bool); ok {
+			currentSettings.HTTPProxyAuth = httpProxyAuth
+			updated = append(updated, "http_proxy_auth")
+		}
+
+		// The load-balancing strategy is looked up per connection and applies immediately
+		if lbStrategy, ok := newSettings["lb_strategy"].(string); ok {
+			if !valid_lb_strategy(lbStrategy) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Unknown load-balancing strategy " + lbStrategy,
+				})
+				return
+			}
+			currentSettings.LBStrategy = lbStrategy
+			updated = append(updated, "lb_strategy")
+		}
+
+		if latencyTolerance, ok := newSettings["latency_tolerance_ms"].(float64); ok && latencyTolerance >= 0 {
+			currentSettings.LatencyToleranceMs = int(latencyTolerance)
+			updated = append(updated, "latency_tolerance_ms")
+		}
+
+		// Sticky sessions: TTL in seconds (0 = off) and whether hosts or registrable domains are pinned
+		if affinityTTL, ok := newSettings["affinity_ttl"].(float64); ok && affinityTTL >= 0 {
+			currentSettings.AffinityTTL = int(affinityTTL)
+			updated = append(updated, "affinity_ttl")
+		}
+		if affinityScope, ok := newSettings["affinity_scope"].(string); ok {
+			if !valid_affinity_scope(affinityScope) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Unknown affinity scope " + affinityScope,
+				})
+				return
+			}
+			currentSettings.AffinityScope = affinityScope
+			updated = append(updated, "affinity_scope")
+		}
+
+		// Spillover stops once a link is this many percent below its thresholds
+		if hysteresis, ok := newSettings["spillover_hysteresis_pct"].(float64); ok {
+			if hysteresis < 0 || hysteresis >= 100 {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Spillover hysteresis must be between 0 and 99 percent",
+				})
+				return
+			}
+			currentSettings.SpilloverHysteresisPct = int(hysteresis)
+			updated = append(updated, "spillover_hysteresis_pct")
+		}
+
+		// Dial failover: how many load balancers to try and how long in total
+		if attempts, ok := newSettings["dial_max_attempts"].(float64); ok {
+			if attempts < 1 {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Dial attempts must be at least 1",
+				})
+				return
+			}
+			currentSettings.DialMaxAttempts = int(attempts)
+			updated = append(updated, "dial_max_attempts")
+		}
+		if budget, ok := newSettings["dial_time_budget"].(float64); ok {
+			if budget < 1 {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Dial time budget must be at least 1 second",
+				})
+				return
+			}
+			currentSettings.DialTimeBudget = int(budget)
+			updated = append(updated, "dial_time_budget")
+		}
+
+		// Happy-eyeballs racing of a second load balancer, race rules can override it per destination
+		if happyEyeballs, ok := newSettings["happy_eyeballs"].(bool); ok {
+			currentSettings.HappyEyeballs = happyEyeballs
+			updated = append(updated, "happy_eyeballs")
+		}
+		if delay, ok := newSettings["happy_eyeballs_delay_ms"].(float64); ok {
+			if delay < 1 {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"error":   "Happy-eyeballs delay must be at least 1 ms",
+				})
+				return
+			}
+			currentSettings.HappyEyeballsDelayMs = int(delay)
+			updated = append(updated, "happy_eyeballs_delay_ms")
+		}
+
+		// Contention ratio auto-tune: validate all values before applying any of them
+		autoTuneMin, autoTuneMax := currentSettings.AutoTuneMinRatio, currentSettings.AutoTuneMaxRatio
+		if v, ok := newSettings["auto_tune_min_ratio"].(float64); ok {
+			autoTuneMin = int(v)
+		}
+		if v, ok := newSettings["auto_tune_max_ratio"].(float64); ok {
+			autoTuneMax = int(v)
+		}
+		if autoTuneMin < 1 || autoTuneMax < autoTuneMin {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Auto-tune bounds must satisfy 1 <= min ratio <= max ratio",
+			})
+			return
+		}
+		if smoothing, ok := newSettings["auto_tune_smoothing"].(float64); ok && (smoothing <= 0 || smoothing > 1) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Auto-tune smoothing must be greater than 0 and at most 1",
+			})
+			return
+		}
+		if interval, ok := newSettings["auto_tune_interval"].(float64); ok && time.Duration(interval)*time.Second < min_auto_tune_interval {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("Auto-tune interval must be at least %d seconds", int(min_auto_tune_interval.Seconds())),
+			})
+			return
+		}
+		if autoTune, ok := newSettings["auto_tune"].(bool); ok {
+			currentSettings.AutoTune = autoTune
+			updated = append(updated, "auto_tune")
+		}
+		if interval, ok := newSettings["auto_tune_interval"].(float64); ok {
+			currentSettings.AutoTuneInterval = int(interval)
+			updated = append(updated, "auto_tune_interval")
+		}
+		if _, ok := newSettings["auto_tune_min_ratio"].(float64); ok {
+			currentSettings.AutoTuneMinRatio = autoTuneMin
+			updated = append(updated, "auto_tune_min_ratio")
+		}
+		if _, ok := newSettings["auto_tune_max_ratio"].(float64); ok {
+			currentSettings.AutoTuneMaxRatio = autoTuneMax
+			updated = append(updated, "auto_tune_max_ratio")
+		}
+		if smoothing, ok := newSettings["auto_tune_smoothing"].(float64); ok {
+			currentSettings.AutoTuneSmoothing = smoothing
+			updated = append(updated, "auto_tune_smoothing")
+		}
+
 		// Gateway mode can be toggled at runtime
 		if gatewayMode, ok := newSettings["gateway_mode"].(bool); ok {
 			currentSettings.GatewayMode = gatewayMode
@@ -1490,27 +2540,35 @@ func (ws *WebServer) handleAPISettings(w http.ResponseWriter, r *http.Request) {
 
 
 
-		// Settings that require restart - but still save them to database
+		// Listen settings move the primary listener live
+		primary_changed := false
 		if listenHost, ok := newSettings["listen_host"].(string); ok && listenHost != "" {
 			currentSettings.ListenHost = listenHost
-			requires_restart = append(requires_restart, "listen_host")
+			primary_changed = true
 			updated = append(updated, "listen_host")
 		}
 		if listenPort, ok := newSettings["listen_port"].(float64); ok {
 			currentSettings.ListenPort = int(listenPort)
-			requires_restart = append(requires_restart, "listen_port")
+			primary_changed = true
 			updated = append(updated, "listen_port")
 		}
+		if tunnelMode, ok := newSettings["tunnel_mode"].(bool); ok {
+			currentSettings.TunnelMode = tunnelMode
+			primary_changed = true
+			updated = append(updated, "tunnel_mode")
+		}
+
+		// Settings that require restart - but still save them to database
+		if httpProxyPort, ok := newSettings["http_proxy_port"].(float64); ok {
+			currentSettings.HTTPProxyPort = int(httpProxyPort)
+			requires_restart = append(requires_restart, "http_proxy_port")
+			updated = append(updated, "http_proxy_port")
+		}
 		if webPort, ok := newSettings["web_port"].(float64); ok {
 			currentSettings.WebPort = int(webPort)
 			requires_restart = append(requires_restart, "web_port")
 			updated = append(updated, "web_port")
 		}
-		if tunnelMode, ok := newSettings["tunnel_mode"].(bool); ok {
-			currentSettings.TunnelMode = tunnelMode
-			requires_restart = append(requires_restart, "tunnel_mode")
-			updated = append(updated, "tunnel_mode")
-		}
 
 
 		// Save all settings to database
@@ -1522,6 +2580,23 @@ func (ws *WebServer) handleAPISettings(w http.ResponseWriter, r *http.Request) {
 			TunnelMode:  currentSettings.TunnelMode,
 			DebugMode:   currentSettings.DebugMode,
 			QuietMode:   currentSettings.QuietMode,
+			SocksAuth:   currentSettings.SocksAuth,
+			HTTPProxyPort: currentSettings.HTTPProxyPort,
+			HTTPProxyAuth: currentSettings.HTTPProxyAuth,
+			LBStrategy:  currentSettings.LBStrategy,
+			LatencyToleranceMs: currentSettings.LatencyToleranceMs,
+			AffinityTTL: currentSettings.AffinityTTL,
+			AffinityScope: currentSettings.AffinityScope,
+			SpilloverHysteresisPct: currentSettings.SpilloverHysteresisPct,
+			AutoTune:    currentSettings.AutoTune,
+			AutoTuneInterval: currentSettings.AutoTuneInterval,
+			AutoTuneMinRatio: currentSettings.AutoTuneMinRatio,
+			AutoTuneMaxRatio: currentSettings.AutoTuneMaxRatio,
+			AutoTuneSmoothing: currentSettings.AutoTuneSmoothing,
+			DialMaxAttempts: currentSettings.DialMaxAttempts,
+			DialTimeBudget: currentSettings.DialTimeBudget,
+			HappyEyeballs: currentSettings.HappyEyeballs,
+			HappyEyeballsDelayMs: currentSettings.HappyEyeballsDelayMs,
 		}
 		if err := saveSettings(dbSettings); err != nil {
 			log.Printf("[ERROR] Failed to save settings to database: %v", err)
@@ -1546,6 +2621,12 @@ func (ws *WebServer) handleAPISettings(w http.ResponseWriter, r *http.Request) {
 		if len(updated) > 0 {
 			message += fmt.Sprintf(" Updated: %s.", strings.Join(updated, ", "))
 		}
+		if primary_changed {
+			if err := update_primary_listener(currentSettings.ListenHost, currentSettings.ListenPort, currentSettings.TunnelMode); err != nil {
+				log.Printf("[WARN] Failed to apply listen settings to the primary listener: %v", err)
+				message += fmt.Sprintf(" The primary listener could not be moved: %v.", err)
+			}
+		}
 		if len(requires_restart) > 0 {
 			message += fmt.Sprintf(" Note: %s require service restart to take effect.", strings.Join(requires_restart, ", "))
 		}
@@ -1562,6 +2643,371 @@ func (ws *WebServer) handleAPISettings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/*
+Handle SOCKS5 proxy users API endpoint
+*/
+func (ws *WebServer) handleAPIUsers(w http.ResponseWriter, r *http.Request) {
+	if !ws.isAuthenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		users, err := loadProxyUsers()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load users: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if users == nil {
+			users = []DBProxyUser{}
+		}
+
+		response := map[string]interface{}{
+			"users":      users,
+			"socks_auth": currentSettings.SocksAuth,
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "POST":
+		// Create user or update password/enabled/description
+		var request struct {
+			Username    string `json:"username"`
+			Password    string `json:"password"`
+			Enabled     *bool  `json:"enabled"`
+			Description string `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		// RFC 1929 limits username and password to 255 bytes each
+		if request.Username == "" || len(request.Username) > 255 || len(request.Password) > 255 {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Username is required and username/password must be at most 255 bytes",
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		user := DBProxyUser{
+			Username:    request.Username,
+			Enabled:     true,
+			Description: request.Description,
+		}
+		if request.Enabled != nil {
+			user.Enabled = *request.Enabled
+		}
+
+		if err := saveProxyUser(user, request.Password); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		log.Printf("[INFO] Proxy user %s saved via WebUI", request.Username)
+		response := map[string]interface{}{
+			"success": true,
+			"message": "User saved successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "DELETE":
+		username := r.URL.Query().Get("username")
+		if username == "" {
+			http.Error(w, "Missing parameters", http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteProxyUser(username); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "User removed successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+Handle proxy listeners API endpoint. Changes are applied live.
+*/
+func (ws *WebServer) handleAPIListeners(w http.ResponseWriter, r *http.Request) {
+	if !ws.isAuthenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		listeners, err := loadListeners()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load listeners: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		result := []map[string]interface{}{}
+		for _, l := range listeners {
+			status, statusError := get_listener_status(l.ID)
+			result = append(result, map[string]interface{}{
+				"id":          l.ID,
+				"bind_host":   l.BindHost,
+				"bind_port":   l.BindPort,
+				"mode":        l.Mode,
+				"enabled":     l.Enabled,
+				"description": l.Description,
+				"status":      status,
+				"error":       statusError,
+				"created_at":  l.CreatedAt,
+				"updated_at":  l.UpdatedAt,
+			})
+		}
+
+		primaryMode := "socks"
+		if currentSettings.TunnelMode {
+			primaryMode = "tunnel"
+		}
+
+		response := map[string]interface{}{
+			"listeners": result,
+			"primary": map[string]interface{}{
+				"bind_host": currentSettings.ListenHost,
+				"bind_port": currentSettings.ListenPort,
+				"mode":      primaryMode,
+			},
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "POST":
+		// Create listener (no id) or update an existing one
+		var request struct {
+			ID          int    `json:"id"`
+			BindHost    string `json:"bind_host"`
+			BindPort    int    `json:"bind_port"`
+			Mode        string `json:"mode"`
+			Enabled     *bool  `json:"enabled"`
+			Description string `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if request.BindHost == "" {
+			request.BindHost = "127.0.0.1"
+		}
+		if request.Mode == "" {
+			request.Mode = "socks"
+		}
+
+		if request.BindPort <= 0 || request.BindPort > 65535 || !valid_listener_mode(request.Mode) ||
+			net.ParseIP(request.BindHost) == nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("Invalid listener: bind_host must be an IP, bind_port 1-65535, mode one of %s", strings.Join(listener_modes, ", ")),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		listener := DBListener{
+			ID:          request.ID,
+			BindHost:    request.BindHost,
+			BindPort:    request.BindPort,
+			Mode:        request.Mode,
+			Enabled:     true,
+			Description: request.Description,
+		}
+		if request.Enabled != nil {
+			listener.Enabled = *request.Enabled
+		}
+
+		id, err := saveListener(listener)
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		listener.ID = id
+		if primary, err := loadPrimaryListener(); err == nil && primary.ID == id {
+			sync_primary_listen_settings(primary)
+		}
+
+		if err := apply_listener(listener); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"id":      id,
+				"error":   fmt.Sprintf("Listener saved but could not be started: %v", err),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		log.Printf("[INFO] Listener %d (%s on %s:%d) saved via WebUI", id, listener.Mode, listener.BindHost, listener.BindPort)
+		response := map[string]interface{}{
+			"success": true,
+			"id":      id,
+			"message": "Listener saved and applied",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Missing parameters", http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteListener(id); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		stop_listener(id)
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "Listener removed successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+Handle API race endpoint for happy-eyeballs settings and race rules
+*/
+func (ws *WebServer) handleAPIRace(w http.ResponseWriter, r *http.Request) {
+	if !ws.isAuthenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		response := map[string]interface{}{
+			"happy_eyeballs":          currentSettings.HappyEyeballs,
+			"happy_eyeballs_delay_ms": currentSettings.HappyEyeballsDelayMs,
+			"rules":                   get_race_rules(),
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "POST":
+		// Create race rule (no id) or update an existing one
+		var request struct {
+			ID          int    `json:"id"`
+			Destination string `json:"destination"`
+			Port        int    `json:"port"`
+			Race        *bool  `json:"race"`
+			DelayMs     int    `json:"delay_ms"`
+			Description string `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if !valid_destination_pattern(request.Destination) || request.Port < 0 || request.Port > 65535 || request.DelayMs < 0 {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Invalid race rule: destination must be *, *.domain, a CIDR block, an IP or a host name, port 0-65535, delay_ms 0 or more",
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		rule := DBRaceRule{
+			ID:          request.ID,
+			Destination: strings.ToLower(strings.TrimSpace(request.Destination)),
+			Port:        request.Port,
+			Race:        true,
+			DelayMs:     request.DelayMs,
+			Description: request.Description,
+		}
+		if request.Race != nil {
+			rule.Race = *request.Race
+		}
+
+		id, err := saveRaceRule(rule)
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_race_rules()
+
+		rule.ID = id
+		log.Printf("[INFO] Race rule %d (%s) saved via WebUI", id, describe_race_rule(rule))
+		response := map[string]interface{}{
+			"success": true,
+			"id":      id,
+			"message": "Race rule saved",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case "DELETE":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Missing parameters", http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteRaceRule(id); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		load_race_rules()
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "Race rule removed successfully",
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 /*
 Handle Network Interfaces API endpoint
 */
@@ -1590,24 +3036,31 @@ func (ws *WebServer) handleAPIInterfaces(w http.ResponseWriter, r *http.Request)
 			}
 			
 			addrs, _ := iface.Addrs()
-			var ipAddr string
-			var hasIPv4 bool
+			var ipAddr, ipv6Addr string
 			
-			// Get IPv4 address if available
+			// Get IPv4 and global IPv6 addresses if available
 			for _, addr := range addrs {
 				if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 					if ipnet.IP.To4() != nil {
-						ipAddr = ipnet.IP.String()
-						hasIPv4 = true
-						break
+						if ipAddr == "" {
+							ipAddr = ipnet.IP.String()
+						}
+					} else if ipv6Addr == "" && !ipnet.IP.IsLinkLocalUnicast() {
+						ipv6Addr = ipnet.IP.String()
 					}
 				}
 			}
+
+			// IPv6-only interfaces can be used as load balancers too
+			if ipAddr == "" {
+				ipAddr = ipv6Addr
+			}
 			
 			interfaceInfo := map[string]interface{}{
 				"name":     iface.Name,
 				"ip":       ipAddr,
-				"has_ip":   hasIPv4,
+				"ipv6":     ipv6Addr,
+				"has_ip":   ipAddr != "",
 				"up":       (iface.Flags & net.FlagUp) != 0,
 				"mtu":      iface.MTU,
 				"flags":    iface.Flags.String(),
@@ -1640,6 +3093,13 @@ func (ws *WebServer) handleAPIAddLB(w http.ResponseWriter, r *http.Request) {
 		Interface       string `json:"interface"`
 		ContentionRatio int    `json:"contention_ratio"`
 		TunnelMode      bool   `json:"tunnel_mode"`
+		CapacityDownMbps int   `json:"capacity_down_mbps"`
+		CapacityUpMbps  int    `json:"capacity_up_mbps"`
+		Tier            int    `json:"tier"`
+		SpillUtilizationPct int `json:"spill_utilization_pct"`
+		SpillMaxConnections int `json:"spill_max_connections"`
+		CostPerGB       float64 `json:"cost_per_gb"`
+		CostTier        int    `json:"cost_tier"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -1663,6 +3123,41 @@ func (ws *WebServer) handleAPIAddLB(w http.ResponseWriter, r *http.Request) {
 		request.ContentionRatio = 1
 	}
 
+	if request.Tier <= 0 {
+		request.Tier = 1
+	}
+
+	if request.CostTier <= 0 {
+		request.CostTier = 1
+	}
+
+	if request.CostPerGB < 0 {
+		response := map[string]interface{}{
+			"success": false,
+			"error":   "Cost per GB cannot be negative",
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if request.CapacityDownMbps < 0 || request.CapacityUpMbps < 0 {
+		response := map[string]interface{}{
+			"success": false,
+			"error":   "Capacity cannot be negative",
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if request.SpillUtilizationPct < 0 || request.SpillUtilizationPct > 100 || request.SpillMaxConnections < 0 {
+		response := map[string]interface{}{
+			"success": false,
+			"error":   "Utilization threshold must be between 0 and 100 and the connection threshold cannot be negative",
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
 	// Check if load balancer already exists
 	mutex.Lock()
 	for _, lb := range lb_list {
@@ -1683,6 +3178,13 @@ func (ws *WebServer) handleAPIAddLB(w http.ResponseWriter, r *http.Request) {
 		Interface:       request.Interface,
 		ContentionRatio: request.ContentionRatio,
 		Enabled:         true,
+		CapacityDownMbps: request.CapacityDownMbps,
+		CapacityUpMbps:  request.CapacityUpMbps,
+		Tier:            request.Tier,
+		SpillUtilizationPct: request.SpillUtilizationPct,
+		SpillMaxConnections: request.SpillMaxConnections,
+		CostPerGB:       request.CostPerGB,
+		CostTier:        request.CostTier,
 	}
 	
 	if err := saveLoadBalancer(dbLB); err != nil {
@@ -1708,6 +3210,13 @@ func (ws *WebServer) handleAPIAddLB(w http.ResponseWriter, r *http.Request) {
 		failure_count:       0,
 		enabled:             true,
 		bytes_transferred:   0,
+		capacity_down_mbps:  request.CapacityDownMbps,
+		capacity_up_mbps:    request.CapacityUpMbps,
+		tier:                request.Tier,
+		spill_utilization_pct: request.SpillUtilizationPct,
+		spill_max_connections: request.SpillMaxConnections,
+		cost_per_gb:         request.CostPerGB,
+		cost_tier:           request.CostTier,
 		last_traffic_update: time.Now(),
 	}
 
@@ -1765,8 +3274,12 @@ func (ws *WebServer) handleAPIRemoveLB(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			
-			// Remove from slice
-			lb_list = append(lb_list[:i], lb_list[i+1:]...)
+			// Remove into a new slice, so load balancers still held by open
+			// connections keep their own entry instead of the next one
+			remaining := make([]enhanced_load_balancer, 0, len(lb_list)-1)
+			remaining = append(remaining, lb_list[:i]...)
+			lb_list = append(remaining, lb_list[i+1:]...)
+			drop_http_transports(request.Address)
 			
 			log.Printf("[INFO] Removed load balancer via WebUI: %s", request.Address)
 			